@@ -20,10 +20,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	ocinfrav1 "github.com/openshift/api/config/v1"
 	"github.com/stolostron/multicloud-operators-application/pkg/apis"
 	"github.com/stolostron/multicloud-operators-application/pkg/controller"
+	"github.com/stolostron/multicloud-operators-application/pkg/controller/application"
 	"github.com/stolostron/multicloud-operators-application/pkg/utils/tlsconfig"
 	"github.com/stolostron/multicloud-operators-application/utils"
 	appWebhook "github.com/stolostron/multicloud-operators-application/webhook"
@@ -35,11 +38,15 @@ import (
 	subapis "open-cluster-management.io/multicloud-operators-subscription/pkg/apis"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -92,6 +99,12 @@ func RunManager() {
 		klog.Info("LeaderElection disabled as not running in a cluster")
 	}
 
+	if options.Shards > 1 {
+		klog.Info("LeaderElection disabled as the applications are sharded over replicas, shards: ", options.Shards)
+
+		enableLeaderElection = false
+	}
+
 	klog.Info("Leader election settings",
 		"leaseDuration", options.LeaderElectionLeaseDuration,
 		"renewDeadline", options.LeaderElectionRenewDeadline,
@@ -110,8 +123,13 @@ func RunManager() {
 	})
 	webhookServer := k8swebhook.NewServer(webhookOption)
 
-	// Create a new Cmd to provide shared dependencies and start components
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	watchNamespaces, err := getWatchNamespaces(runtimeClient)
+	if err != nil {
+		klog.Error("Failed to select the watched namespaces: ", err)
+		os.Exit(1)
+	}
+
+	mgrOptions := ctrl.Options{
 		Metrics: metricsserver.Options{
 			BindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
 		},
@@ -122,7 +140,16 @@ func RunManager() {
 		RenewDeadline:           &options.LeaderElectionRenewDeadline,
 		RetryPeriod:             &options.LeaderElectionRetryPeriod,
 		WebhookServer:           webhookServer,
-	})
+	}
+
+	if len(watchNamespaces) > 0 {
+		klog.Info("Watching namespaces: ", strings.Join(watchNamespaces, ","))
+
+		setNamespacedOptions(&mgrOptions, watchNamespaces)
+	}
+
+	// Create a new Cmd to provide shared dependencies and start components
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOptions)
 
 	if err != nil {
 		klog.Error(err, "")
@@ -149,21 +176,23 @@ func RunManager() {
 		os.Exit(1)
 	}
 
-	dpllist := &dplv1.DeployableList{}
-	err = runtimeClient.List(context.TODO(), dpllist, &client.ListOptions{})
-
-	if err != nil && !errors.IsNotFound(err) {
-		klog.Fatal("Deployable kind is not ready in api server, exit and retry later")
+	if err := checkKindsReady(runtimeClient, watchNamespaces); err != nil {
+		klog.Fatal(err, ", exit and retry later")
 		os.Exit(1)
 	}
 
-	sublist := &subv1.SubscriptionList{}
-	err = runtimeClient.List(context.TODO(), sublist, &client.ListOptions{})
-
-	if err != nil && !errors.IsNotFound(err) {
-		klog.Fatal("Subscription kind is not ready in api server, exit and retry later")
-		os.Exit(1)
-	}
+	application.Options.LegacyAnnotations = options.LegacyAnnotations
+	application.Options.MembershipAnnotationLimit = options.MembershipAnnotationLimit
+	application.Options.Discovery = options.Discovery
+	application.Options.WatchNamespaces = watchNamespaces
+	application.Options.Shards = options.Shards
+	application.Options.ShardLeaseDuration = options.ShardLeaseDuration
+	application.Options.MaxConcurrentReconciles = options.MaxConcurrentReconciles
+	application.Options.BackoffBase = options.BackoffBase
+	application.Options.BackoffMax = options.BackoffMax
+	application.Options.QPS = options.QPS
+	application.Options.Burst = options.Burst
+	application.Options.EnqueueDebounce = options.EnqueueDebounce
 
 	// Setup all Controllers
 	if err := controller.AddToManager(mgr); err != nil {
@@ -201,3 +230,87 @@ func RunManager() {
 		os.Exit(1)
 	}
 }
+
+// getWatchNamespaces returns the sorted namespaces given by --watch-namespaces and selected by --watch-namespace-selector,
+// none to watch all namespaces
+func getWatchNamespaces(clt client.Client) ([]string, error) {
+	namespaces := make(map[string]bool)
+
+	for _, namespace := range options.WatchNamespaces {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			namespaces[namespace] = true
+		}
+	}
+
+	if options.WatchNamespaceSelector != "" {
+		selector, err := labels.Parse(options.WatchNamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+
+		nsList := &corev1.NamespaceList{}
+		if err := clt.List(context.TODO(), nsList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+
+		if len(nsList.Items) == 0 && len(namespaces) == 0 {
+			return nil, fmt.Errorf("no namespace matches the watch namespace selector %q", options.WatchNamespaceSelector)
+		}
+
+		for _, ns := range nsList.Items {
+			namespaces[ns.Name] = true
+		}
+	}
+
+	watchNamespaces := make([]string, 0, len(namespaces))
+	for namespace := range namespaces {
+		watchNamespaces = append(watchNamespaces, namespace)
+	}
+
+	sort.Strings(watchNamespaces)
+
+	return watchNamespaces, nil
+}
+
+// checkKindsReady probes the Deployable and Subscription kinds. With watched namespaces, they are only listed in those
+// namespaces, the controller may only be allowed to list them there.
+func checkKindsReady(clt client.Client, watchNamespaces []string) error {
+	namespaces := watchNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	for _, namespace := range namespaces {
+		dpllist := &dplv1.DeployableList{}
+		err := clt.List(context.TODO(), dpllist, client.InNamespace(namespace), client.Limit(1))
+
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("deployable kind is not ready in api server: %w", err)
+		}
+
+		sublist := &subv1.SubscriptionList{}
+		err = clt.List(context.TODO(), sublist, client.InNamespace(namespace), client.Limit(1))
+
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("subscription kind is not ready in api server: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// setNamespacedOptions restricts the manager cache to the watched namespaces. Namespaces are cluster scoped,
+// they are read from the apiserver instead of a cluster-wide informer. The leader election lease moves to the
+// controller namespace, so the controller runs with Roles in the watched namespaces.
+func setNamespacedOptions(mgrOptions *ctrl.Options, watchNamespaces []string) {
+	mgrOptions.Cache.DefaultNamespaces = make(map[string]cache.Config, len(watchNamespaces))
+
+	for _, namespace := range watchNamespaces {
+		mgrOptions.Cache.DefaultNamespaces[namespace] = cache.Config{}
+	}
+
+	mgrOptions.Client.Cache = &client.CacheOptions{DisableFor: []client.Object{&corev1.Namespace{}}}
+
+	// the namespace of the controller pod
+	mgrOptions.LeaderElectionNamespace = ""
+}
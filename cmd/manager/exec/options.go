@@ -28,6 +28,19 @@ type ControllerRunOptions struct {
 	LeaderElectionLeaseDuration time.Duration
 	LeaderElectionRenewDeadline time.Duration
 	LeaderElectionRetryPeriod   time.Duration
+	LegacyAnnotations           bool
+	MembershipAnnotationLimit   int
+	Discovery                   bool
+	WatchNamespaces             []string
+	WatchNamespaceSelector      string
+	Shards                      int
+	ShardLeaseDuration          time.Duration
+	MaxConcurrentReconciles     int
+	BackoffBase                 time.Duration
+	BackoffMax                  time.Duration
+	QPS                         float64
+	Burst                       int
+	EnqueueDebounce             time.Duration
 }
 
 var options = ControllerRunOptions{
@@ -36,6 +49,14 @@ var options = ControllerRunOptions{
 	LeaderElectionLeaseDuration: 137 * time.Second,
 	LeaderElectionRenewDeadline: 107 * time.Second,
 	LeaderElectionRetryPeriod:   26 * time.Second,
+	LegacyAnnotations:           true,
+	MembershipAnnotationLimit:   128 * 1024,
+	ShardLeaseDuration:          30 * time.Second,
+	MaxConcurrentReconciles:     1,
+	BackoffBase:                 5 * time.Millisecond,
+	BackoffMax:                  1000 * time.Second,
+	QPS:                         10,
+	Burst:                       100,
 }
 
 // ProcessFlags parses command line parameters into options
@@ -91,4 +112,104 @@ func ProcessFlags() {
 		"The duration the clients should wait between attempting acquisition and renewal "+
 			"of a leadership. This is only applicable if leader election is enabled.",
 	)
+
+	flag.BoolVar(
+		&options.LegacyAnnotations,
+		"legacy-annotations",
+		options.LegacyAnnotations,
+		"Keep writing the comma-joined subscriptions and deployables annotations on the application "+
+			"in addition to the application status.",
+	)
+
+	flag.IntVar(
+		&options.MembershipAnnotationLimit,
+		"membership-annotation-limit",
+		options.MembershipAnnotationLimit,
+		"The size in bytes of the subscriptions and deployables annotations and status.components above which the "+
+			"application members are written to a companion ConfigMap instead.",
+	)
+
+	flag.BoolVar(
+		&options.Discovery,
+		"discovery",
+		options.Discovery,
+		"Generate an application for each subscription selected by no application, "+
+			"and delete it when its last subscription is gone.",
+	)
+
+	flag.StringSliceVar(
+		&options.WatchNamespaces,
+		"watch-namespaces",
+		options.WatchNamespaces,
+		"The comma-separated namespaces the controller watches. All namespaces are watched "+
+			"when neither this nor --watch-namespace-selector is set.",
+	)
+
+	flag.StringVar(
+		&options.WatchNamespaceSelector,
+		"watch-namespace-selector",
+		options.WatchNamespaceSelector,
+		"The label selector of the namespaces the controller watches, in addition to --watch-namespaces. "+
+			"The namespaces are selected when the controller starts.",
+	)
+
+	flag.IntVar(
+		&options.Shards,
+		"shards",
+		options.Shards,
+		"The number of shards the applications are spread over by the hash of their namespace. "+
+			"When greater than 1, every replica is active and reconciles the shards it holds the lease of, "+
+			"instead of electing a leader.",
+	)
+
+	flag.DurationVar(
+		&options.ShardLeaseDuration,
+		"shard-lease-duration",
+		options.ShardLeaseDuration,
+		"The duration a shard stays with a replica that stopped renewing its lease. The leases are renewed "+
+			"every third of the duration.",
+	)
+
+	flag.IntVar(
+		&options.MaxConcurrentReconciles,
+		"max-concurrent-reconciles",
+		options.MaxConcurrentReconciles,
+		"The number of applications reconciled at the same time.",
+	)
+
+	flag.DurationVar(
+		&options.BackoffBase,
+		"backoff-base",
+		options.BackoffBase,
+		"The first retry delay of a failing application reconcile, doubled on each failure.",
+	)
+
+	flag.DurationVar(
+		&options.BackoffMax,
+		"backoff-max",
+		options.BackoffMax,
+		"The longest retry delay of a failing application reconcile.",
+	)
+
+	flag.Float64Var(
+		&options.QPS,
+		"reconcile-qps",
+		options.QPS,
+		"The number of application reconciles per second, all applications together.",
+	)
+
+	flag.IntVar(
+		&options.Burst,
+		"reconcile-burst",
+		options.Burst,
+		"The number of application reconciles allowed above --reconcile-qps in a burst.",
+	)
+
+	flag.DurationVar(
+		&options.EnqueueDebounce,
+		"enqueue-debounce",
+		options.EnqueueDebounce,
+		"The delay of the application reconciles triggered by subscription, deployable, namespace and placement "+
+			"changes. The changes within the delay are coalesced into one reconcile per application.",
+	)
 }
@@ -18,6 +18,8 @@ import (
 	"crypto/tls"
 
 	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	plrv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	v1beta1 "sigs.k8s.io/application/api/v1beta1"
 )
@@ -31,5 +33,6 @@ const (
 
 func init() {
 	// Register the types with the Scheme so the components can map objects to GroupVersionKinds and back
-	AddToSchemes = append(AddToSchemes, v1beta1.SchemeBuilder.AddToScheme, dplv1.SchemeBuilder.AddToScheme, subv1.SchemeBuilder.AddToScheme)
+	AddToSchemes = append(AddToSchemes, v1beta1.SchemeBuilder.AddToScheme, dplv1.SchemeBuilder.AddToScheme, subv1.SchemeBuilder.AddToScheme,
+		plrv1.SchemeBuilder.AddToScheme, clusterv1beta1.Install)
 }
@@ -16,15 +16,23 @@ package application
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
 
 	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
 	"github.com/stolostron/multicloud-operators-application/utils"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	plrv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 
 	"strings"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
@@ -36,6 +44,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
+// fieldManager is the field manager of the application writes made by the controller
+const fieldManager = "multicluster-operators-application"
+
 // Add creates a new Application Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
@@ -43,13 +54,16 @@ func Add(mgr manager.Manager) error {
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
+func newReconciler(mgr manager.Manager) *ReconcileApplication {
 	erecorder, _ := utils.NewEventRecorder(mgr.GetConfig(), mgr.GetScheme())
 
 	return &ReconcileApplication{
-		Client:        mgr.GetClient(),
-		scheme:        mgr.GetScheme(),
-		eventRecorder: erecorder,
+		Client:            mgr.GetClient(),
+		apiReader:         mgr.GetAPIReader(),
+		scheme:            mgr.GetScheme(),
+		eventRecorder:     erecorder,
+		memberIndex:       newMemberIndex(),
+		publishedClusters: make(map[types.NamespacedName][]utils.ClusterStatus),
 	}
 }
 
@@ -58,55 +72,41 @@ type deployableMapper struct {
 }
 
 func (mapper *deployableMapper) Map(ctx context.Context, obj *dplv1.Deployable) []reconcile.Request {
-	//enqueue all applications under these namespaces including the deployable namespace plus all of subscription namespaces related to the deployable
+	//enqueue the applications selecting the deployable, plus the applications selecting the subscriptions related to the deployable
 	dplName := obj.GetName()
 	dplNamespace := obj.GetNamespace()
 	klog.V(1).Info("In deployable Mapper:", dplName, "/", dplNamespace)
 
-	nsmap := make(map[string]bool)
-	nsmap[dplNamespace] = true
+	finder := newMemberAppFinder(mapper.Client)
 
-	var requests []reconcile.Request
+	// the generated deployables are not application members
+	if obj.GetAnnotations()[dplv1.AnnotationIsGenerated] != "true" {
+		finder.addObject(utils.DeployableGroupKind, dplNamespace, obj.GetLabels())
+	}
 
+	// the subscriptions whose channel is in the deployable namespace
 	subscriptionList := &subv1.SubscriptionList{}
-	listOptions := &client.ListOptions{}
-	err := mapper.List(context.TODO(), subscriptionList, listOptions)
+	err := mapper.List(context.TODO(), subscriptionList, client.MatchingFields{subscriptionChannelNamespaceIndex: dplNamespace})
 
 	if err != nil {
 		klog.Error("Failed to list all subscription objects. ", "error: ", err)
-		return requests
+		return finder.getRequests()
 	}
 
-	for _, subscription := range subscriptionList.Items {
-		strs := strings.Split(subscription.Spec.Channel, "/")
-		if len(strs) == 2 {
-			subChannelns := strs[0]
-			if subChannelns == dplNamespace {
-				nsmap[subscription.Namespace] = true
-			}
-		}
-	}
+	// the subscription deployed by the hosting deployable
+	if hosting := strings.Split(utils.GetHostingSubscription(obj), "/"); len(hosting) == 2 && isWatchedNamespace(hosting[0]) {
+		hostSub := &subv1.Subscription{}
 
-	applicationList := &appv1beta1.ApplicationList{}
-	err = mapper.List(context.TODO(), applicationList, listOptions)
-
-	if err != nil {
-		klog.Error("Failed to list all subscription objects. ", "error: ", err)
-		return requests
+		if mapper.Get(context.TODO(), types.NamespacedName{Namespace: hosting[0], Name: hosting[1]}, hostSub) == nil {
+			subscriptionList.Items = append(subscriptionList.Items, *hostSub)
+		}
 	}
 
-	for _, app := range applicationList.Items {
-		if nsmap[app.GetNamespace()] {
-			objkey := types.NamespacedName{
-				Name:      app.GetName(),
-				Namespace: app.GetNamespace(),
-			}
-
-			requests = append(requests, reconcile.Request{NamespacedName: objkey})
-		}
+	for _, subscription := range subscriptionList.Items {
+		finder.addObject(utils.SubscriptionGroupKind, subscription.Namespace, subscription.Labels)
 	}
 
-	return requests
+	return finder.getRequests()
 }
 
 type subscriptionMapper struct {
@@ -114,55 +114,74 @@ type subscriptionMapper struct {
 }
 
 func (mapper *subscriptionMapper) Map(ctx context.Context, obj *subv1.Subscription) []reconcile.Request {
-	//enqueue all applications under the subscription namespace
+	//enqueue the applications selecting the subscription
 	subName := obj.GetName()
 	subNamespace := obj.GetNamespace()
 	klog.V(1).Info("In subscription Mapper:", subName, "/", subNamespace)
 
-	var requests []reconcile.Request
+	finder := newMemberAppFinder(mapper.Client)
+	finder.addObject(utils.SubscriptionGroupKind, subNamespace, obj.GetLabels())
 
-	applicationList := &appv1beta1.ApplicationList{}
-	listOptions := &client.ListOptions{Namespace: subNamespace}
-	err := mapper.List(context.TODO(), applicationList, listOptions)
+	return finder.getRequests()
+}
 
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r *ReconcileApplication) error {
+	// Index the cache for the mappers
+	err := setupIndexes(context.TODO(), mgr.GetFieldIndexer())
 	if err != nil {
-		klog.Error("Failed to list all application objects. ", "error: ", err)
-		return requests
-	}
-
-	for _, app := range applicationList.Items {
-		objkey := types.NamespacedName{
-			Name:      app.GetName(),
-			Namespace: app.GetNamespace(),
-		}
-
-		requests = append(requests, reconcile.Request{NamespacedName: objkey})
+		return err
 	}
 
-	return requests
-}
-
-// add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// Create a new controller
-	c, err := controller.New("application-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("application-controller", mgr, newControllerOptions(r))
 	if err != nil {
 		return err
 	}
 
+	// Components of other kinds declared by applications are watched on demand
+	r.componentWatcher = &componentWatcher{
+		Client:     mgr.GetClient(),
+		cache:      mgr.GetCache(),
+		restMapper: mgr.GetRESTMapper(),
+		controller: c,
+		watched:    make(map[schema.GroupVersionKind]bool),
+	}
+
 	// Watch for changes to primary resource Application
 	err = c.Watch(source.Kind(mgr.GetCache(), &appv1beta1.Application{}, &handler.TypedEnqueueRequestForObject[*appv1beta1.Application]{}))
 	if err != nil {
 		return err
 	}
 
-	// Watch for changes to Deployable
+	// Spread the applications over the replicas, the applications of the acquired shards are enqueued
+	if Options.Shards > 1 {
+		namespace := os.Getenv(podNamespaceEnvVar)
+		if namespace == "" {
+			return fmt.Errorf("the %s environment variable must name the controller namespace holding the shard leases",
+				podNamespaceEnvVar)
+		}
+
+		r.shards = newShardManager(mgr.GetClient(), mgr.GetAPIReader(), namespace, Options.Shards, Options.ShardLeaseDuration)
+
+		err = c.Watch(source.Channel(r.shards.events, &handler.TypedEnqueueRequestForObject[*appv1beta1.Application]{}))
+		if err != nil {
+			return err
+		}
+
+		if err := mgr.Add(r.shards); err != nil {
+			return err
+		}
+	}
+
+	// Watch for changes to Deployable. The member graph gets all events, the status changes too.
+	r.memberGraph = newMemberGraph()
 	dmapper := &deployableMapper{mgr.GetClient()}
 
 	err = c.Watch(
 		source.Kind(mgr.GetCache(), &dplv1.Deployable{},
-			handler.TypedEnqueueRequestsFromMapFunc(dmapper.Map),
-			utils.DeployablePredicateFunc))
+			trackMembers(r.memberGraph, debounce(handler.TypedEnqueueRequestsFromMapFunc(dmapper.Map)),
+				utils.DeployablePredicateFunc)))
 	if err != nil {
 		return err
 	}
@@ -172,13 +191,60 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 
 	err = c.Watch(
 		source.Kind(mgr.GetCache(), &subv1.Subscription{},
-			handler.TypedEnqueueRequestsFromMapFunc(smapper.Map),
-			utils.SubscriptionPredicateFunc))
+			trackMembers(r.memberGraph, debounce(handler.TypedEnqueueRequestsFromMapFunc(smapper.Map)),
+				utils.SubscriptionPredicateFunc)))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// Watch for changes to Namespace, they may join or leave the applications selecting members from other namespaces.
+	// Namespaces are cluster scoped, they are not watched when the controller is restricted to some namespaces.
+	if len(Options.WatchNamespaces) == 0 {
+		nsmapper := &namespaceMapper{mgr.GetClient()}
+
+		err = c.Watch(
+			source.Kind(mgr.GetCache(), &corev1.Namespace{},
+				debounce(handler.TypedEnqueueRequestsFromMapFunc(nsmapper.Map)),
+				utils.NamespacePredicateFunc))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Watch for changes to the placement decisions, they decide the managed clusters of subscriptions without hosting deployable.
+	// The placement kinds are only watched when installed on the hub.
+	pmapper := &placementMapper{mgr.GetClient()}
+
+	if isKindInstalled(mgr.GetRESTMapper(), clusterv1beta1.SchemeGroupVersion.WithKind("PlacementDecision")) {
+		err = c.Watch(
+			source.Kind(mgr.GetCache(), &clusterv1beta1.PlacementDecision{},
+				debounce(handler.TypedEnqueueRequestsFromMapFunc(pmapper.MapDecision))))
+		if err != nil {
+			return err
+		}
+	}
+
+	if isKindInstalled(mgr.GetRESTMapper(), plrv1.SchemeGroupVersion.WithKind(placementRuleKind)) {
+		err = c.Watch(
+			source.Kind(mgr.GetCache(), &plrv1.PlacementRule{},
+				debounce(handler.TypedEnqueueRequestsFromMapFunc(pmapper.MapRule))))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Generate the applications of the subscriptions selected by no application
+	if Options.Discovery {
+		if err := addDiscovery(mgr, r.shards); err != nil {
+			return err
+		}
+	}
+
+	// Report the subscriptions and deployables selected by no application
+	orphanReporter := newOrphanReporter(mgr.GetClient(), mgr.GetAPIReader())
+	orphanReporter.shards = r.shards
+
+	return mgr.Add(orphanReporter)
 }
 
 // blank assignment to verify that ReconcileApplication implements reconcile.Reconciler
@@ -189,8 +255,56 @@ type ReconcileApplication struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
 	client.Client
-	scheme        *runtime.Scheme
-	eventRecorder *utils.EventRecorder
+	// apiReader reads the application from the apiserver, to retry the conflicting writes on a fresh copy
+	apiReader        client.Reader
+	scheme           *runtime.Scheme
+	eventRecorder    *utils.EventRecorder
+	componentWatcher *componentWatcher
+	memberIndex      *memberIndex
+	// memberGraph holds the components kept current by the watches, nil to list them from the cache on each reconcile
+	memberGraph *memberGraph
+	// shards tells the applications reconciled by the replica, nil when not sharded
+	shards *shardManager
+
+	// publishedClusters keeps the last per-cluster status written for each application.
+	// The typed application doesn't carry status.clusters, so it can't be compared with the cached object.
+	clustersLock      sync.Mutex
+	publishedClusters map[types.NamespacedName][]utils.ClusterStatus
+}
+
+// isClustersChanged tells if the per-cluster status differs from the last one written for the application
+func (r *ReconcileApplication) isClustersChanged(key types.NamespacedName, clusters []utils.ClusterStatus) bool {
+	r.clustersLock.Lock()
+	defer r.clustersLock.Unlock()
+
+	published, ok := r.publishedClusters[key]
+
+	return !ok || !reflect.DeepEqual(published, clusters)
+}
+
+// getPublishedClusters returns the per-cluster status written for the application, the given clusters if none is known yet
+func (r *ReconcileApplication) getPublishedClusters(key types.NamespacedName, clusters []utils.ClusterStatus) []utils.ClusterStatus {
+	r.clustersLock.Lock()
+	defer r.clustersLock.Unlock()
+
+	if published, ok := r.publishedClusters[key]; ok {
+		return published
+	}
+
+	return clusters
+}
+
+// setPublishedClusters records the per-cluster status written for the application, nil to forget the application
+func (r *ReconcileApplication) setPublishedClusters(key types.NamespacedName, clusters []utils.ClusterStatus) {
+	r.clustersLock.Lock()
+	defer r.clustersLock.Unlock()
+
+	if clusters == nil {
+		delete(r.publishedClusters, key)
+		return
+	}
+
+	r.publishedClusters[key] = clusters
 }
 
 // Reconcile reads that state of the cluster for a Application object and makes changes based on the state read
@@ -199,6 +313,12 @@ type ReconcileApplication struct {
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
 func (r *ReconcileApplication) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	// the application is reconciled by the replica owning its shard
+	if !r.shards.ownsNamespace(request.Namespace) {
+		klog.V(1).Info("Skipping application of a shard owned by another replica:", request.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
 	// Fetch the Deployable instance
 	instance := &appv1beta1.Application{}
 	err := r.Get(ctx, request.NamespacedName, instance)
@@ -210,7 +330,17 @@ func (r *ReconcileApplication) Reconcile(ctx context.Context, request reconcile.
 			// validate all deployables, remove the deployables whose hosting deployables are gone
 			klog.Info("Reconciling - finished.", request.NamespacedName, " with Get err:", err)
 
-			return reconcile.Result{}, err
+			r.setPublishedClusters(request.NamespacedName, nil)
+
+			if r.memberGraph != nil {
+				r.memberGraph.forgetApp(request.NamespacedName)
+			}
+
+			if _, err := r.syncMemberOwners(ctx, request.NamespacedName, nil); err != nil {
+				return reconcile.Result{}, err
+			}
+
+			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		klog.Info("Reconciling - finished.", request.NamespacedName, " with Get err:", err)
@@ -218,24 +348,207 @@ func (r *ReconcileApplication) Reconcile(ctx context.Context, request reconcile.
 		return reconcile.Result{}, err
 	}
 
+	if instance.GetDeletionTimestamp() != nil {
+		return r.finalizeApp(ctx, instance)
+	}
+
+	if err := r.ensureAppFinalizer(ctx, instance); err != nil {
+		klog.Error("Failed to add the finalizer to application:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+		return reconcile.Result{}, err
+	}
+
+	// the spilled application is compared with its components read from the companion ConfigMap
+	loaded, err := r.loadSpilledComponents(ctx, instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
 	oldInstance := instance.DeepCopy()
 
-	r.doAppHubReconcile(instance)
+	clusters, err := r.doAppHubReconcile(instance)
+	if err != nil {
+		// keep the last known membership, the error requeues the request with the exponential backoff of the controller
+		return reconcile.Result{}, r.setReconcileError(ctx, oldInstance, err)
+	}
+
+	// the generated application goes with its last subscription
+	if utils.IsGeneratedApp(instance) && len(instance.Status.ComponentList.Objects) == 0 {
+		klog.Info("Deleting generated application without subscriptions:", request.NamespacedName)
+
+		if err := r.Delete(ctx, instance); err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+
+		return reconcile.Result{}, nil
+	}
+
+	overlaps, err := r.syncMemberOwners(ctx, request.NamespacedName, instance.Status.ComponentList.Objects)
+	if err != nil {
+		klog.Error("Failed to update the owner applications of components:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+		return reconcile.Result{}, err
+	}
+
+	setOverlappingMembershipCondition(instance, overlaps)
+	r.recordOverlappingMembership(oldInstance, instance)
+
+	if err := r.spillAppMembers(ctx, instance); err != nil {
+		klog.Error("Failed to write the members ConfigMap of application:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+		return reconcile.Result{}, err
+	}
 
 	result := reconcile.Result{}
 
+	// the update response carries the stored status, keep the computed one for the status update
+	newStatus := instance.Status.DeepCopy()
+
+	change := utils.GetMembershipChange(oldInstance.Status.ComponentList, newStatus.ComponentList,
+		r.getPublishedClusters(request.NamespacedName, clusters), clusters)
+
 	if utils.UpdateAppInstance(oldInstance, instance) {
 		klog.V(1).Infoln("Update app annotation", instance.Annotations)
 
-		addtionalMsg := "The app annotations updated. App:" + instance.Namespace + "/" + instance.Name
-		r.eventRecorder.RecordEvent(instance, "Update", addtionalMsg, nil)
+		// only the fields written by the controller are patched, the edits of users and other controllers are kept
+		patch, err := utils.GetAppOwnedFieldsPatch(instance)
+		if err != nil {
+			klog.Error("Failed to build application patch:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+			return reconcile.Result{}, err
+		}
 
-		err = r.Update(ctx, instance)
+		err = r.Patch(ctx, instance, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager))
 		if err != nil {
 			klog.Error("Error returned when updating application :", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
 			return reconcile.Result{}, err
 		}
+
+		if oldInstance.Spec.AssemblyPhase != instance.Spec.AssemblyPhase {
+			r.recordAssemblyPhase(instance, newStatus)
+		}
+	}
+
+	// the change is written first, a failed status update reports it again on the next reconcile
+	if change != nil {
+		if err := r.recordMembershipChange(ctx, instance, change); err != nil {
+			klog.Error("Error returned when updating application membership change:", err,
+				"instance:", instance.GetNamespace()+"/"+instance.GetName())
+			return reconcile.Result{}, err
+		}
+	}
+
+	if utils.IsAppStatusChanged(&oldInstance.Status, newStatus) || r.isClustersChanged(request.NamespacedName, clusters) ||
+		loaded != isSpilledApp(instance) {
+		klog.V(1).Infoln("Update app status", newStatus.ComponentsReady, newStatus.Conditions, clusters)
+
+		// status.clusters is not part of the sig application status, patch the owned status fields only
+		patch, err := utils.GetAppStatusPatch(getStoredAppStatus(instance, newStatus), clusters)
+		if err != nil {
+			klog.Error("Failed to build application status patch:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+			return reconcile.Result{}, err
+		}
+
+		err = r.Status().Patch(ctx, instance, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager))
+		if err != nil {
+			klog.Error("Error returned when updating application status:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+			return reconcile.Result{}, err
+		}
+
+		r.setPublishedClusters(request.NamespacedName, clusters)
+	}
+
+	if err := r.syncAppOwnerReferences(ctx, instance, newStatus.ComponentList.Objects); err != nil {
+		klog.Error("Error returned when updating owner references of application components:", err,
+			"instance:", instance.GetNamespace()+"/"+instance.GetName())
+		return reconcile.Result{}, err
 	}
 
 	return result, nil
 }
+
+// recordOverlappingMembership records a warning event when the components shared with other applications change
+func (r *ReconcileApplication) recordOverlappingMembership(oldInstance, instance *appv1beta1.Application) {
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)
+	if cond == nil || r.eventRecorder == nil {
+		return
+	}
+
+	oldCond := utils.GetAppCondition(&oldInstance.Status, utils.AppConditionOverlappingMembership)
+	if oldCond != nil && oldCond.Message == cond.Message {
+		return
+	}
+
+	r.eventRecorder.RecordEvent(instance, string(utils.AppConditionOverlappingMembership), cond.Message,
+		fmt.Errorf("%s", cond.Message))
+}
+
+// recordMembershipChange records an event listing the members joining and leaving the application,
+// and writes the change to status.lastMembershipChange
+func (r *ReconcileApplication) recordMembershipChange(ctx context.Context, instance *appv1beta1.Application,
+	change *utils.MembershipChange) error {
+	msg := utils.DescribeMembershipChange(change)
+
+	klog.Info("Membership of application: ", instance.GetNamespace()+"/"+instance.GetName(), " changed, ", msg)
+
+	if r.eventRecorder != nil {
+		r.eventRecorder.RecordEvent(instance, utils.ReasonMembershipChanged, msg, nil)
+	}
+
+	patch, err := utils.GetMembershipChangePatch(change)
+	if err != nil {
+		return err
+	}
+
+	return r.Status().Patch(ctx, instance, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager))
+}
+
+// recordAssemblyPhase records an event for the new assembly phase of the application, a warning if the assembly failed
+func (r *ReconcileApplication) recordAssemblyPhase(instance *appv1beta1.Application, status *appv1beta1.ApplicationStatus) {
+	msg := "The application assembly phase is " + string(instance.Spec.AssemblyPhase)
+
+	if cond := utils.GetAppCondition(status, utils.AppConditionAssembled); cond != nil {
+		msg += ": " + cond.Message
+	}
+
+	klog.Info(msg, " instance: ", instance.GetNamespace()+"/"+instance.GetName())
+
+	if r.eventRecorder == nil {
+		return
+	}
+
+	var err error
+	if instance.Spec.AssemblyPhase == appv1beta1.Failed {
+		err = fmt.Errorf("%s", msg)
+	}
+
+	r.eventRecorder.RecordEvent(instance, string(utils.AppConditionAssembled), msg, err)
+}
+
+// setReconcileError reports the failure to read the application components with a warning event and the ReconcileError condition.
+// Only the conditions are patched, so the membership in the annotations and status is kept. The reconcile error is returned.
+func (r *ReconcileApplication) setReconcileError(ctx context.Context, instance *appv1beta1.Application, reconcileErr error) error {
+	klog.Error("Failed to read the components of application: ", instance.GetNamespace()+"/"+instance.GetName(), " err: ", reconcileErr)
+
+	if r.eventRecorder != nil {
+		r.eventRecorder.RecordEvent(instance, string(utils.AppConditionReconcileError),
+			"Failed to read the application components, the last known membership is kept: "+reconcileErr.Error(), reconcileErr)
+	}
+
+	oldStatus := instance.Status.DeepCopy()
+
+	utils.SetAppCondition(&instance.Status, utils.AppConditionReconcileError, corev1.ConditionTrue, utils.ReasonComponentsNotRead,
+		reconcileErr.Error())
+
+	if !utils.IsAppStatusChanged(oldStatus, &instance.Status) {
+		return reconcileErr
+	}
+
+	patch, err := utils.GetAppConditionsPatch(&instance.Status)
+	if err != nil {
+		klog.Error("Failed to build application conditions patch:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+		return reconcileErr
+	}
+
+	if err := r.Status().Patch(ctx, instance, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager)); err != nil {
+		klog.Error("Error returned when updating application conditions:", err, "instance:", instance.GetNamespace()+"/"+instance.GetName())
+	}
+
+	return reconcileErr
+}
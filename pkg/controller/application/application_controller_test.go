@@ -29,6 +29,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
 	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
@@ -172,4 +173,30 @@ func TestReconcile(t *testing.T) {
 	//verify the subscription and deployable are all reported by the application annotation
 	g.Expect(instanceApp.Annotations["apps.open-cluster-management.io/deployables"]).To(gomega.Equal(deployableKey.String()))
 	g.Expect(instanceApp.Annotations["apps.open-cluster-management.io/subscriptions"]).To(gomega.Equal(subscriptionKey.String()))
+
+	//verify the subscription and deployable are all reported by the application status
+	g.Expect(instanceApp.Status.ComponentList.Objects).To(gomega.HaveLen(2))
+	g.Expect(instanceApp.Status.ComponentsReady).To(gomega.Equal("0/2"))
+	g.Expect(instanceApp.Status.ObservedGeneration).To(gomega.Equal(instanceApp.Generation))
+	g.Expect(instanceApp.Status.Conditions).To(gomega.HaveLen(5))
+
+	//no component is ready yet, the application is not assembled
+	g.Expect(instanceApp.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.Pending))
+
+	//the subscription has neither a hosting deployable nor a placement, its managed clusters are unknown
+	g.Expect(utils.GetAppCondition(&instanceApp.Status, utils.AppConditionClustersResolved).Reason).To(gomega.Equal(utils.ReasonClustersNotFound))
+
+	//narrow the application down to subscriptions, the deployable is no longer a member
+	instanceApp.Spec.ComponentGroupKinds = []metav1.GroupKind{{Group: "apps.open-cluster-management.io", Kind: "Subscription"}}
+	err = c.Update(context.TODO(), instanceApp)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	time.Sleep(4 * time.Second)
+
+	err = c.Get(context.TODO(), applicationKey, instanceApp)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(instanceApp.Annotations["apps.open-cluster-management.io/deployables"]).To(gomega.Equal(""))
+	g.Expect(instanceApp.Annotations["apps.open-cluster-management.io/subscriptions"]).To(gomega.Equal(subscriptionKey.String()))
+	g.Expect(instanceApp.Status.ComponentList.Objects).To(gomega.HaveLen(1))
 }
@@ -0,0 +1,188 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// componentListTimeout bounds the wait for the informer of a newly watched component kind to sync
+const componentListTimeout = 30 * time.Second
+
+// componentWatcher starts the watches of the component kinds declared by applications on demand.
+// Subscriptions and deployables are always watched, so they are not handled here.
+type componentWatcher struct {
+	client.Client
+	cache      cache.Cache
+	restMapper meta.RESTMapper
+	controller controller.Controller
+
+	lock    sync.Mutex
+	watched map[schema.GroupVersionKind]bool
+}
+
+// getMapping returns the rest mapping of the component kind
+func (w *componentWatcher) getMapping(gk metav1.GroupKind) (*meta.RESTMapping, error) {
+	return w.restMapper.RESTMapping(schema.GroupKind{Group: gk.Group, Kind: gk.Kind})
+}
+
+// ensureWatch starts watching the component kind if it is not watched yet
+func (w *componentWatcher) ensureWatch(gk metav1.GroupKind, gvk schema.GroupVersionKind) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.watched[gvk] {
+		return nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+
+	cmapper := &componentMapper{Client: w.Client, gk: gk}
+
+	err := w.controller.Watch(source.Kind(w.cache, obj, debounce(handler.TypedEnqueueRequestsFromMapFunc(cmapper.Map))))
+	if err != nil {
+		return err
+	}
+
+	klog.Info("Started watching application component kind: ", gvk.String())
+
+	w.watched[gvk] = true
+
+	return nil
+}
+
+// listComponents lists the components of the kind selected by the application in its member namespaces
+func (w *componentWatcher) listComponents(app *appv1beta1.Application, namespaces []string,
+	mapping *meta.RESTMapping) ([]unstructured.Unstructured, error) {
+	var objs []unstructured.Unstructured
+
+	var selector labels.Selector
+
+	if app.Spec.Selector != nil {
+		var err error
+
+		selector, err = utils.ConvertLabels(app.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), componentListTimeout)
+	defer cancel()
+
+	for _, namespace := range namespaces {
+		objList := &unstructured.UnstructuredList{}
+		objList.SetGroupVersionKind(mapping.GroupVersionKind.GroupVersion().WithKind(mapping.GroupVersionKind.Kind + "List"))
+
+		if err := w.cache.List(ctx, objList, &client.ListOptions{Namespace: namespace, LabelSelector: selector}); err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, objList.Items...)
+	}
+
+	return objs, nil
+}
+
+// getAllComponentsByApplication get the health of all components of the kinds declared by the application,
+// other than subscriptions and deployables. The kinds unknown to the hub are skipped.
+func (r *ReconcileApplication) getAllComponentsByApplication(app *appv1beta1.Application,
+	namespaces []string) ([]utils.ComponentHealth, error) {
+	var healths []utils.ComponentHealth
+
+	if r.componentWatcher == nil {
+		return healths, nil
+	}
+
+	for _, gk := range app.Spec.ComponentGroupKinds {
+		if gk == utils.SubscriptionGroupKind || gk == utils.DeployableGroupKind {
+			continue
+		}
+
+		mapping, err := r.componentWatcher.getMapping(gk)
+		if err != nil {
+			klog.Error("Failed to find the component kind of application: ", app.Namespace+"/"+app.Name, " kind: ", gk.String(), " err: ", err)
+			continue
+		}
+
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			klog.Info("Skip cluster scoped component kind of application: ", app.Namespace+"/"+app.Name, " kind: ", gk.String())
+			continue
+		}
+
+		if err := r.componentWatcher.ensureWatch(gk, mapping.GroupVersionKind); err != nil {
+			klog.Error("Failed to watch the component kind of application: ", app.Namespace+"/"+app.Name, " kind: ", gk.String(), " err: ", err)
+			return nil, err
+		}
+
+		objs, err := r.componentWatcher.listComponents(app, namespaces, mapping)
+		if err != nil {
+			klog.Error("Failed to list the components of application: ", app.Namespace+"/"+app.Name, " kind: ", gk.String(), " err: ", err)
+			return nil, err
+		}
+
+		for i := range objs {
+			healths = append(healths, utils.GetUnstructuredHealth(&objs[i], getComponentLink(mapping, &objs[i])))
+		}
+	}
+
+	klog.V(1).Infoln("Got all other components in the application: ", app.Name, "|", len(healths))
+
+	return healths, nil
+}
+
+// getComponentLink returns the api path of the component
+func getComponentLink(mapping *meta.RESTMapping, obj *unstructured.Unstructured) string {
+	if mapping.Resource.Group == "" {
+		return fmt.Sprintf("/api/%s/namespaces/%s/%s/%s", mapping.Resource.Version, obj.GetNamespace(), mapping.Resource.Resource, obj.GetName())
+	}
+
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s/%s", mapping.Resource.Group, mapping.Resource.Version,
+		obj.GetNamespace(), mapping.Resource.Resource, obj.GetName())
+}
+
+type componentMapper struct {
+	client.Client
+	gk metav1.GroupKind
+}
+
+func (mapper *componentMapper) Map(ctx context.Context, obj *unstructured.Unstructured) []reconcile.Request {
+	//enqueue the applications selecting the component
+	klog.V(1).Info("In component Mapper:", mapper.gk.String(), " ", obj.GetName(), "/", obj.GetNamespace())
+
+	finder := newMemberAppFinder(mapper.Client)
+	finder.addObject(mapper.gk, obj.GetNamespace(), obj.GetLabels())
+
+	return finder.getRequests()
+}
@@ -0,0 +1,204 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"time"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// discoveryRequeuePeriod is how often a subscription waits for the terminating application of the same name to go
+const discoveryRequeuePeriod = 10 * time.Second
+
+// addDiscovery adds the discovery controller to mgr, it generates an application for each subscription no application selects
+func addDiscovery(mgr manager.Manager, shards *shardManager) error {
+	r := &ReconcileDiscovery{
+		Client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
+		shards:    shards,
+	}
+
+	c, err := controller.New("application-discovery", mgr, newControllerOptions(r))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(
+		source.Kind(mgr.GetCache(), &subv1.Subscription{},
+			&handler.TypedEnqueueRequestForObject[*subv1.Subscription]{},
+			utils.SubscriptionPredicateFunc))
+	if err != nil {
+		return err
+	}
+
+	// the subscriptions of the acquired shards may have changed while another replica owned them
+	if shards != nil {
+		shards.subEvents = make(chan event.TypedGenericEvent[*subv1.Subscription], 1024)
+
+		err = c.Watch(source.Channel(shards.subEvents, &handler.TypedEnqueueRequestForObject[*subv1.Subscription]{}))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the subscriptions of a deleted application may be selected by no application anymore
+	amapper := &appSubscriptionMapper{mgr.GetClient()}
+
+	return c.Watch(
+		source.Kind(mgr.GetCache(), &appv1beta1.Application{},
+			handler.TypedEnqueueRequestsFromMapFunc(amapper.Map),
+			predicate.TypedFuncs[*appv1beta1.Application]{
+				CreateFunc: func(event.TypedCreateEvent[*appv1beta1.Application]) bool { return false },
+				UpdateFunc: func(event.TypedUpdateEvent[*appv1beta1.Application]) bool { return false },
+			}))
+}
+
+type appSubscriptionMapper struct {
+	client.Client
+}
+
+func (mapper *appSubscriptionMapper) Map(ctx context.Context, obj *appv1beta1.Application) []reconcile.Request {
+	//enqueue the subscriptions selected by the deleted application
+	subscriptionList := &subv1.SubscriptionList{}
+
+	err := mapper.List(ctx, subscriptionList, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		klog.Error("Failed to list subscription objects in namespace ", obj.GetNamespace(), " error: ", err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+
+	for _, sub := range subscriptionList.Items {
+		if utils.IsAppSelectingObject(obj, utils.SubscriptionGroupKind, sub.Labels) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: sub.Name, Namespace: sub.Namespace}})
+		}
+	}
+
+	return requests
+}
+
+// blank assignment to verify that ReconcileDiscovery implements reconcile.Reconciler
+var _ reconcile.Reconciler = &ReconcileDiscovery{}
+
+// ReconcileDiscovery generates an application for each subscription no application selects
+type ReconcileDiscovery struct {
+	client.Client
+	// apiReader lists the applications from the apiserver, so an application just generated for another subscription is seen
+	apiReader client.Reader
+	// shards tells the subscriptions handled by the replica, nil when not sharded
+	shards *shardManager
+}
+
+// Reconcile creates the application of the subscription if no application selects it. The application is named after the
+// subscription, selects the subscription labels and has the Subscription component kind. The application controller deletes
+// it once it selects no subscription anymore.
+func (r *ReconcileDiscovery) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	// the application of the subscription is generated by the replica owning the shard of the namespace
+	if !r.shards.ownsNamespace(request.Namespace) {
+		return reconcile.Result{}, nil
+	}
+
+	sub := &subv1.Subscription{}
+
+	if err := r.Get(ctx, request.NamespacedName, sub); err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	if sub.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, nil
+	}
+
+	// an application can't select a subscription without labels alone
+	if len(sub.Labels) == 0 {
+		klog.V(1).Info("Skipping the discovery of subscription without labels: ", request.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+
+	if len(newMemberAppFinder(r.Client).getAuthorizedSelectingApps(utils.SubscriptionGroupKind, sub.Namespace, sub.Labels)) > 0 {
+		return reconcile.Result{}, nil
+	}
+
+	// the cache may miss the applications generated for other subscriptions of the namespace
+	applicationList := &appv1beta1.ApplicationList{}
+	if err := r.apiReader.List(ctx, applicationList, client.InNamespace(sub.Namespace)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	for i := range applicationList.Items {
+		app := &applicationList.Items[i]
+
+		if app.Name == sub.Name && app.GetDeletionTimestamp() != nil {
+			klog.Info("Waiting for the terminating application of subscription: ", request.NamespacedName)
+			return reconcile.Result{RequeueAfter: discoveryRequeuePeriod}, nil
+		}
+
+		if app.Name == sub.Name || utils.IsAppSelectingObject(app, utils.SubscriptionGroupKind, sub.Labels) {
+			return reconcile.Result{}, nil
+		}
+	}
+
+	app := newGeneratedApp(sub)
+
+	klog.Info("Generating application for subscription: ", request.NamespacedName)
+
+	err := r.Create(ctx, app, client.FieldOwner(fieldManager))
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// newGeneratedApp returns the application selecting the subscription
+func newGeneratedApp(sub *subv1.Subscription) *appv1beta1.Application {
+	matchLabels := make(map[string]string, len(sub.Labels))
+	for key, value := range sub.Labels {
+		matchLabels[key] = value
+	}
+
+	return &appv1beta1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        sub.Name,
+			Namespace:   sub.Namespace,
+			Labels:      map[string]string{utils.LabelGeneratedApp: "true"},
+			Annotations: map[string]string{utils.AnnotationGeneratedFrom: sub.Name},
+		},
+		Spec: appv1beta1.ApplicationSpec{
+			ComponentGroupKinds: []metav1.GroupKind{utils.SubscriptionGroupKind},
+			Selector:            &metav1.LabelSelector{MatchLabels: matchLabels},
+		},
+	}
+}
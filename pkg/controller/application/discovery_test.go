@@ -0,0 +1,141 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDiscoveryGeneratesApplication(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	nginxApp := newEnqueueTestApp("nginx", "app-ns", map[string]string{"app": "nginx"})
+
+	wrapped := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+	unwrapped := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "redis-sub", Namespace: "app-ns", Labels: map[string]string{"app": "redis"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+	unlabeled := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "bare-sub", Namespace: "app-ns"},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, nginxApp, wrapped, unwrapped, unlabeled)
+	discovery := &ReconcileDiscovery{Client: r.Client, apiReader: r.Client}
+
+	for _, sub := range []*subv1.Subscription{wrapped, unwrapped, unlabeled} {
+		_, err := discovery.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: sub.Name, Namespace: sub.Namespace}})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	appList := &appv1beta1.ApplicationList{}
+	g.Expect(r.List(context.TODO(), appList)).To(gomega.Succeed())
+	g.Expect(appList.Items).To(gomega.HaveLen(2))
+
+	generated := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "redis-sub", Namespace: "app-ns"}, generated)).To(gomega.Succeed())
+	g.Expect(utils.IsGeneratedApp(generated)).To(gomega.BeTrue())
+	g.Expect(generated.Annotations[utils.AnnotationGeneratedFrom]).To(gomega.Equal("redis-sub"))
+	g.Expect(generated.Spec.ComponentGroupKinds).To(gomega.Equal([]metav1.GroupKind{utils.SubscriptionGroupKind}))
+	g.Expect(utils.IsAppSelectingObject(generated, utils.SubscriptionGroupKind, unwrapped.Labels)).To(gomega.BeTrue())
+
+	// the subscription is wrapped now, nothing more is generated
+	_, err := discovery.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "redis-sub", Namespace: "app-ns"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.List(context.TODO(), appList)).To(gomega.Succeed())
+	g.Expect(appList.Items).To(gomega.HaveLen(2))
+
+	appKey := types.NamespacedName{Name: "redis-sub", Namespace: "app-ns"}
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.Get(context.TODO(), appKey, generated)).To(gomega.Succeed())
+	g.Expect(generated.GetDeletionTimestamp()).To(gomega.BeNil())
+
+	// the last subscription is gone, so is the generated application
+	g.Expect(r.Delete(context.TODO(), unwrapped)).To(gomega.Succeed())
+
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	err = r.Get(context.TODO(), appKey, generated)
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+
+	// a user application without members is kept
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "nginx", Namespace: "app-ns"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.Delete(context.TODO(), wrapped)).To(gomega.Succeed())
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "nginx", Namespace: "app-ns"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "nginx", Namespace: "app-ns"}, nginxApp)).To(gomega.Succeed())
+}
+
+func TestDiscoveryIgnoresDeniedApplication(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	crossApp := newEnqueueTestApp("cross", "tenant-ns", map[string]string{"app": "nginx"})
+	crossApp.Annotations = map[string]string{utils.AnnotationMemberNamespaces: "denied-ns"}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx-sub", Namespace: "denied-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, crossApp, sub, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "denied-ns"}})
+	discovery := &ReconcileDiscovery{Client: r.Client, apiReader: r.Client}
+
+	// the namespace denies the cross namespace application, so the subscription is not wrapped
+	_, err := discovery.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: sub.Name, Namespace: sub.Namespace}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	generated := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: sub.Name, Namespace: sub.Namespace}, generated)).To(gomega.Succeed())
+	g.Expect(utils.IsGeneratedApp(generated)).To(gomega.BeTrue())
+}
+
+func TestAppSubscriptionMapper(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("nginx", "app-ns", map[string]string{"app": "nginx"})
+
+	r := newHubTestReconciler(interceptor.Funcs{},
+		&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "nginx-sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}}},
+		&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "redis-sub", Namespace: "app-ns", Labels: map[string]string{"app": "redis"}}},
+		&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "nginx-sub", Namespace: "other-ns", Labels: map[string]string{"app": "nginx"}}},
+	)
+
+	mapper := &appSubscriptionMapper{r.Client}
+	g.Expect(mapper.Map(context.TODO(), app)).To(gomega.ConsistOf(
+		reconcile.Request{NamespacedName: types.NamespacedName{Name: "nginx-sub", Namespace: "app-ns"}},
+	))
+}
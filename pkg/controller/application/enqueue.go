@@ -0,0 +1,190 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// memberAppFinder collects the requests of the applications selecting the given objects.
+// The applications are listed once per namespace, so a mapper can check many objects cheaply.
+// On update the mapped handler maps both the old and the new object, so the applications losing the object are found too.
+type memberAppFinder struct {
+	client.Client
+
+	apps        map[string][]appv1beta1.Application
+	crossApps   []appv1beta1.Application
+	crossListed bool
+	namespaces  map[string]*corev1.Namespace
+	requests    map[types.NamespacedName]bool
+}
+
+func newMemberAppFinder(clt client.Client) *memberAppFinder {
+	return &memberAppFinder{
+		Client:     clt,
+		apps:       make(map[string][]appv1beta1.Application),
+		namespaces: make(map[string]*corev1.Namespace),
+		requests:   make(map[types.NamespacedName]bool),
+	}
+}
+
+// addObject adds the applications selecting the object of the group kind, namespace and labels
+func (f *memberAppFinder) addObject(gk metav1.GroupKind, namespace string, objLabels map[string]string) {
+	for _, app := range f.getSelectingApps(gk, namespace, objLabels) {
+		f.requests[types.NamespacedName{Name: app.Name, Namespace: app.Namespace}] = true
+	}
+}
+
+// getSelectingApps returns the applications selecting the object of the group kind, namespace and labels
+func (f *memberAppFinder) getSelectingApps(gk metav1.GroupKind, namespace string, objLabels map[string]string) []*appv1beta1.Application {
+	var apps []*appv1beta1.Application
+
+	for i := range f.listApps(namespace) {
+		if app := &f.apps[namespace][i]; utils.IsAppSelectingObject(app, gk, objLabels) {
+			apps = append(apps, app)
+		}
+	}
+
+	for i := range f.listCrossApps() {
+		app := &f.crossApps[i]
+
+		if app.Namespace != namespace && f.isMemberNamespace(app, namespace) && utils.IsAppSelectingObject(app, gk, objLabels) {
+			apps = append(apps, app)
+		}
+	}
+
+	return apps
+}
+
+// getRequests returns the requests of all applications found
+func (f *memberAppFinder) getRequests() []reconcile.Request {
+	var requests []reconcile.Request
+
+	for objkey := range f.requests {
+		requests = append(requests, reconcile.Request{NamespacedName: objkey})
+	}
+
+	return requests
+}
+
+func (f *memberAppFinder) listApps(namespace string) []appv1beta1.Application {
+	if apps, ok := f.apps[namespace]; ok {
+		return apps
+	}
+
+	if !isWatchedNamespace(namespace) {
+		return nil
+	}
+
+	applicationList := &appv1beta1.ApplicationList{}
+
+	err := f.List(context.TODO(), applicationList, client.InNamespace(namespace))
+	if err != nil {
+		klog.Error("Failed to list application objects in namespace ", namespace, " error: ", err)
+	}
+
+	f.apps[namespace] = applicationList.Items
+
+	return applicationList.Items
+}
+
+func (f *memberAppFinder) listCrossApps() []appv1beta1.Application {
+	if f.crossListed {
+		return f.crossApps
+	}
+
+	applicationList := &appv1beta1.ApplicationList{}
+
+	err := f.List(context.TODO(), applicationList, client.MatchingFields{applicationCrossNamespaceIndex: "true"})
+	if err != nil {
+		klog.Error("Failed to list all application objects. ", "error: ", err)
+	}
+
+	f.crossApps = applicationList.Items
+	f.crossListed = true
+
+	return f.crossApps
+}
+
+// isMemberNamespace tells if the cross namespace application requests members from the namespace.
+// The namespace authorization is left to the reconcile.
+func (f *memberAppFinder) isMemberNamespace(app *appv1beta1.Application, namespace string) bool {
+	for _, nsName := range strings.Split(app.Annotations[utils.AnnotationMemberNamespaces], ",") {
+		if strings.TrimSpace(nsName) == namespace {
+			return true
+		}
+	}
+
+	selectorStr := app.Annotations[utils.AnnotationMemberNamespaceSelector]
+	if selectorStr == "" {
+		return false
+	}
+
+	selector, err := labels.Parse(selectorStr)
+	if err != nil {
+		return false
+	}
+
+	ns := f.getNamespace(namespace)
+
+	return ns != nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+// getAuthorizedSelectingApps returns the applications selecting the object, without the applications of other namespaces
+// the object namespace doesn't allow, as the reconcile does
+func (f *memberAppFinder) getAuthorizedSelectingApps(gk metav1.GroupKind, namespace string,
+	objLabels map[string]string) []*appv1beta1.Application {
+	var apps []*appv1beta1.Application
+
+	for _, app := range f.getSelectingApps(gk, namespace, objLabels) {
+		if app.Namespace == namespace {
+			apps = append(apps, app)
+			continue
+		}
+
+		if ns := f.getNamespace(namespace); ns != nil && utils.IsAppNamespaceAllowed(ns, app.Namespace) {
+			apps = append(apps, app)
+		}
+	}
+
+	return apps
+}
+
+// getNamespace returns the namespace, nil if it can't be read
+func (f *memberAppFinder) getNamespace(namespace string) *corev1.Namespace {
+	ns, ok := f.namespaces[namespace]
+	if !ok {
+		ns = &corev1.Namespace{}
+
+		if err := f.Get(context.TODO(), types.NamespacedName{Name: namespace}, ns); err != nil {
+			ns = nil
+		}
+
+		f.namespaces[namespace] = ns
+	}
+
+	return ns
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newEnqueueTestApp(name, namespace string, matchLabels map[string]string) *appv1beta1.Application {
+	return &appv1beta1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appv1beta1.ApplicationSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: matchLabels},
+		},
+	}
+}
+
+func TestSubscriptionMapper(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	nginxApp := newEnqueueTestApp("nginx", "app-ns", map[string]string{"app": "nginx"})
+	redisApp := newEnqueueTestApp("redis", "app-ns", map[string]string{"app": "redis"})
+
+	// selects deployables only
+	dplApp := newEnqueueTestApp("dpl-only", "app-ns", map[string]string{"app": "nginx"})
+	dplApp.Spec.ComponentGroupKinds = []metav1.GroupKind{utils.DeployableGroupKind}
+
+	// selects members from the namespaces labeled team=web
+	crossApp := newEnqueueTestApp("cross", "other-ns", map[string]string{"app": "nginx"})
+	crossApp.Annotations = map[string]string{utils.AnnotationMemberNamespaceSelector: "team=web"}
+
+	appNs := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app-ns", Labels: map[string]string{"team": "web"}}}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(nginxApp, redisApp, dplApp, crossApp, appNs).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	smapper := &subscriptionMapper{Client: clt}
+
+	oldSub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+	}
+	g.Expect(getRequestKeys(smapper.Map(context.TODO(), oldSub))).To(gomega.ConsistOf("app-ns/nginx", "other-ns/cross"))
+
+	// the subscription moves from nginx to redis, the applications losing it and gaining it are both enqueued
+	newSub := oldSub.DeepCopy()
+	newSub.Labels = map[string]string{"app": "redis"}
+
+	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+	defer queue.ShutDown()
+
+	handler.TypedEnqueueRequestsFromMapFunc(smapper.Map).Update(context.TODO(),
+		event.TypedUpdateEvent[*subv1.Subscription]{ObjectOld: oldSub, ObjectNew: newSub}, queue)
+
+	var keys []string
+
+	for queue.Len() > 0 {
+		req, _ := queue.Get()
+		keys = append(keys, req.String())
+		queue.Done(req)
+	}
+
+	g.Expect(keys).To(gomega.ConsistOf("app-ns/nginx", "app-ns/redis", "other-ns/cross"))
+}
+
+func TestMemberAppFinderNamespaces(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	crossApp := newEnqueueTestApp("cross", "other-ns", nil)
+	crossApp.Annotations = map[string]string{utils.AnnotationMemberNamespaces: "ns1, ns2"}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(crossApp).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	finder := newMemberAppFinder(clt)
+	finder.addObject(utils.SubscriptionGroupKind, "ns3", nil)
+	g.Expect(finder.getRequests()).To(gomega.BeEmpty())
+
+	finder.addObject(utils.SubscriptionGroupKind, "ns2", nil)
+	g.Expect(getRequestKeys(finder.getRequests())).To(gomega.ConsistOf("other-ns/cross"))
+}
+
+func getRequestKeys(requests []reconcile.Request) []string {
+	keys := []string{}
+
+	for _, req := range requests {
+		keys = append(keys, req.String())
+	}
+
+	return keys
+}
@@ -0,0 +1,290 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// terminatingRequeuePeriod is how often the deleted application checks its components are gone
+const terminatingRequeuePeriod = 10 * time.Second
+
+// ensureAppFinalizer adds the cleanup finalizer to the application
+func (r *ReconcileApplication) ensureAppFinalizer(ctx context.Context, app *appv1beta1.Application) error {
+	return r.patchAppFinalizers(ctx, app, controllerutil.AddFinalizer)
+}
+
+// patchAppFinalizers adds or removes the cleanup finalizer with a merge patch locked to the read resource version,
+// so the finalizers of others are kept. A conflict is retried on a fresh read of the application.
+func (r *ReconcileApplication) patchAppFinalizers(ctx context.Context, app *appv1beta1.Application,
+	mutate func(client.Object, string) bool) error {
+	fresh := false
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if fresh {
+			if err := r.getAPIReader().Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+				return err
+			}
+		}
+
+		fresh = true
+
+		patch := client.MergeFromWithOptions(app.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+		if !mutate(app, utils.AppFinalizer) {
+			return nil
+		}
+
+		return r.Patch(ctx, app, patch, client.FieldOwner(fieldManager))
+	})
+}
+
+// finalizeApp cleans up the components of the deleted application by its deletion policy, then releases the application.
+// The subscriptions are deleted before the deployables, and the cleanup progress is reported in the Terminating condition.
+func (r *ReconcileApplication) finalizeApp(ctx context.Context, app *appv1beta1.Application) (reconcile.Result, error) {
+	if !controllerutil.ContainsFinalizer(app, utils.AppFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	namespaces, _, err := r.getAppNamespaces(app)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	allSubs, allDpls, _, _, err := r.GetAllNewDeployablesByApplication(app, namespaces)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	policy := utils.GetAppDeletionPolicy(app)
+
+	klog.Info("Finalizing application: ", app.Namespace+"/"+app.Name, " policy: ", policy,
+		" subscriptions: ", len(allSubs), " deployables: ", len(allDpls))
+
+	if policy == utils.DeletionPolicyCascade && utils.IsAppSelectingAll(app) {
+		klog.Info("Refusing cascade deletion of application selecting all components: ", app.Namespace+"/"+app.Name)
+
+		r.setTerminatingProgress(ctx, app, utils.ReasonCascadeRefused,
+			"Cascade deletion refused, the application selects all components of its namespaces, they are orphaned")
+
+		policy = utils.DeletionPolicyOrphan
+	}
+
+	if policy == utils.DeletionPolicyCascade {
+		subs := make([]client.Object, 0, len(allSubs))
+		for _, sub := range allSubs {
+			subs = append(subs, sub)
+		}
+
+		dpls := make([]client.Object, 0, len(allDpls))
+		for _, dpl := range allDpls {
+			dpls = append(dpls, dpl)
+		}
+
+		subs, keptSubs, err := r.splitCascadeComponents(ctx, app, subs)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		dpls, keptDpls, err := r.splitCascadeComponents(ctx, app, dpls)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		if err := r.removeAppReferences(ctx, app, append(keptSubs, keptDpls...)); err != nil {
+			return reconcile.Result{}, err
+		}
+
+		if len(subs) > 0 {
+			return r.deleteAppComponents(ctx, app, subs, utils.ReasonDeletingSubscriptions, "subscriptions")
+		}
+
+		if len(dpls) > 0 {
+			return r.deleteAppComponents(ctx, app, dpls, utils.ReasonDeletingDeployables, "deployables")
+		}
+	} else {
+		objs := make([]client.Object, 0, len(allSubs)+len(allDpls))
+		for _, sub := range allSubs {
+			objs = append(objs, sub)
+		}
+
+		for _, dpl := range allDpls {
+			objs = append(objs, dpl)
+		}
+
+		if err := r.removeAppReferences(ctx, app, objs); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	r.memberIndex.setAppMembers(app.Namespace+"/"+app.Name, nil)
+
+	klog.Info("Finalized application: ", app.Namespace+"/"+app.Name)
+
+	if err := r.patchAppFinalizers(ctx, app, controllerutil.RemoveFinalizer); err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// splitCascadeComponents returns the components the cascade deletion deletes, and the components it keeps: the components
+// in other namespaces than the application, and the components other applications select too
+func (r *ReconcileApplication) splitCascadeComponents(ctx context.Context, app *appv1beta1.Application,
+	objs []client.Object) ([]client.Object, []client.Object, error) {
+	if err := r.seedMemberIndex(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	appKey := app.Namespace + "/" + app.Name
+
+	var deleted, kept []client.Object
+
+	for _, obj := range objs {
+		shared := false
+
+		for _, owner := range r.getShardedOwners(getComponentKey(obj), obj.GetAnnotations()[utils.AnnotationAppOwners]) {
+			if owner != appKey {
+				shared = true
+				break
+			}
+		}
+
+		if shared || obj.GetNamespace() != app.Namespace {
+			klog.Info("Keeping component shared with other applications or namespaces: ", obj.GetNamespace()+"/"+obj.GetName(),
+				" application: ", appKey)
+
+			kept = append(kept, obj)
+
+			continue
+		}
+
+		deleted = append(deleted, obj)
+	}
+
+	return deleted, kept, nil
+}
+
+// deleteAppComponents deletes the components not being deleted yet, and waits for all of them to be gone
+func (r *ReconcileApplication) deleteAppComponents(ctx context.Context, app *appv1beta1.Application, objs []client.Object,
+	reason, kindName string) (reconcile.Result, error) {
+	deleting := 0
+
+	for _, obj := range objs {
+		if obj.GetDeletionTimestamp() != nil {
+			deleting++
+			continue
+		}
+
+		klog.Info("Deleting component of application: ", app.Namespace+"/"+app.Name, " component: ", obj.GetNamespace()+"/"+obj.GetName())
+
+		err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+
+		deleting++
+	}
+
+	r.setTerminatingProgress(ctx, app, reason, fmt.Sprintf("Waiting for %d %s to be deleted", deleting, kindName))
+
+	return reconcile.Result{RequeueAfter: terminatingRequeuePeriod}, nil
+}
+
+// removeAppReferences removes the owner references to the application from its components,
+// and the application from their owner applications annotation
+func (r *ReconcileApplication) removeAppReferences(ctx context.Context, app *appv1beta1.Application, objs []client.Object) error {
+	if len(objs) > 0 {
+		r.setTerminatingProgress(ctx, app, utils.ReasonRemovingReferences,
+			fmt.Sprintf("Removing the references to the application from %d components", len(objs)))
+	}
+
+	for _, obj := range objs {
+		patch := client.MergeFromWithOptions(obj.DeepCopyObject().(client.Object), client.MergeFromWithOptimisticLock{})
+
+		removedRef := removeAppOwnerReference(obj, app)
+		removedOwner := removeFromOwnersAnnotation(obj, app)
+
+		if !removedRef && !removedOwner {
+			continue
+		}
+
+		err := r.Patch(ctx, obj, patch)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeAppOwnerReference removes the owner references to the application from the object, tells if any is removed
+func removeAppOwnerReference(obj client.Object, app *appv1beta1.Application) bool {
+	var owners []metav1.OwnerReference
+
+	for _, owner := range obj.GetOwnerReferences() {
+		if !utils.IsAppOwnerReference(owner, app) {
+			owners = append(owners, owner)
+		}
+	}
+
+	if len(owners) == len(obj.GetOwnerReferences()) {
+		return false
+	}
+
+	obj.SetOwnerReferences(owners)
+
+	return true
+}
+
+// setTerminatingProgress reports the cleanup progress in the Terminating condition and an event, when the progress changes
+func (r *ReconcileApplication) setTerminatingProgress(ctx context.Context, app *appv1beta1.Application, reason, message string) {
+	oldStatus := app.Status.DeepCopy()
+
+	utils.SetAppCondition(&app.Status, utils.AppConditionTerminating, corev1.ConditionTrue, reason, message)
+
+	if !utils.IsAppStatusChanged(oldStatus, &app.Status) {
+		return
+	}
+
+	if r.eventRecorder != nil {
+		r.eventRecorder.RecordEvent(app, string(utils.AppConditionTerminating), message, nil)
+	}
+
+	patch, err := utils.GetAppConditionsPatch(&app.Status)
+	if err != nil {
+		klog.Error("Failed to build application conditions patch:", err, "instance:", app.GetNamespace()+"/"+app.GetName())
+		return
+	}
+
+	err = r.Status().Patch(ctx, app, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager))
+	if err != nil {
+		klog.Error("Error returned when updating application conditions:", err, "instance:", app.GetNamespace()+"/"+app.GetName())
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var finalizerTestAppKey = types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+func newFinalizerTestObjects(policy string) (*appv1beta1.Application, *subv1.Subscription, *dplv1.Deployable) {
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.Finalizers = []string{utils.AppFinalizer}
+	app.Annotations = map[string]string{utils.AnnotationDeletionPolicy: policy}
+	app.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"},
+			Annotations: map[string]string{utils.AnnotationAppOwners: "app-ns/app,app-ns/other"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: appv1beta1.GroupVersion.String(), Kind: "Application", Name: "app"},
+			},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	dpl := &dplv1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+	}
+
+	return app, sub, dpl
+}
+
+func TestReconcileAddsFinalizer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	r := newHubTestReconciler(interceptor.Funcs{}, newEnqueueTestApp("app", "app-ns", nil))
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), finalizerTestAppKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Finalizers).To(gomega.ContainElement(utils.AppFinalizer))
+}
+
+func TestEnsureAppFinalizerRetriesConflict(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", nil)
+	app.Finalizers = []string{"example.com/other"}
+
+	edited := false
+
+	// another controller removes its finalizer after the application is read, the first patch conflicts
+	r := newHubTestReconciler(interceptor.Funcs{
+		Patch: func(ctx context.Context, clt client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if !edited {
+				edited = true
+
+				other := &appv1beta1.Application{}
+				if err := clt.Get(ctx, finalizerTestAppKey, other); err != nil {
+					return err
+				}
+
+				other.Finalizers = nil
+
+				if err := clt.Update(ctx, other); err != nil {
+					return err
+				}
+			}
+
+			return clt.Patch(ctx, obj, patch, opts...)
+		},
+	}, app)
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), finalizerTestAppKey, instance)).To(gomega.Succeed())
+	g.Expect(r.ensureAppFinalizer(context.TODO(), instance)).To(gomega.Succeed())
+
+	g.Expect(r.Get(context.TODO(), finalizerTestAppKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Finalizers).To(gomega.Equal([]string{utils.AppFinalizer}))
+}
+
+func TestFinalizeAppOrphan(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app, sub, dpl := newFinalizerTestObjects("")
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub, dpl)
+
+	result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.BeZero())
+
+	// the components are kept without the references to the application, the application is gone
+	instanceSub := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.OwnerReferences).To(gomega.BeEmpty())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/other"))
+
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "dpl", Namespace: "app-ns"}, &dplv1.Deployable{})).To(gomega.Succeed())
+
+	err = r.Get(context.TODO(), finalizerTestAppKey, &appv1beta1.Application{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+}
+
+func TestFinalizeAppCascade(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app, sub, dpl := newFinalizerTestObjects(utils.DeletionPolicyCascade)
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub, dpl)
+
+	// the subscriptions go first
+	result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.Equal(terminatingRequeuePeriod))
+
+	err = r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, &subv1.Subscription{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "dpl", Namespace: "app-ns"}, &dplv1.Deployable{})).To(gomega.Succeed())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), finalizerTestAppKey, instance)).To(gomega.Succeed())
+
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionTerminating)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Reason).To(gomega.Equal(utils.ReasonDeletingSubscriptions))
+	g.Expect(cond.Message).To(gomega.Equal("Waiting for 1 subscriptions to be deleted"))
+
+	// then the deployables
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	err = r.Get(context.TODO(), types.NamespacedName{Name: "dpl", Namespace: "app-ns"}, &dplv1.Deployable{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+
+	// then the application is released
+	result, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.BeZero())
+
+	err = r.Get(context.TODO(), finalizerTestAppKey, &appv1beta1.Application{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+}
+
+func TestFinalizeAppCascadeKeepsSharedComponents(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app, sub, dpl := newFinalizerTestObjects(utils.DeletionPolicyCascade)
+
+	// the other application selects the subscription too
+	other := newEnqueueTestApp("other", "app-ns", map[string]string{"app": "nginx"})
+	other.Spec.ComponentGroupKinds = []metav1.GroupKind{utils.SubscriptionGroupKind}
+	other.Status.ComponentList.Objects = []appv1beta1.ObjectStatus{utils.GetSubscriptionHealth(sub).ObjectStatus}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, other, sub, dpl)
+
+	// the shared subscription is kept, the deployable is deleted
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instanceSub := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.OwnerReferences).To(gomega.BeEmpty())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/other"))
+
+	err = r.Get(context.TODO(), types.NamespacedName{Name: "dpl", Namespace: "app-ns"}, &dplv1.Deployable{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	err = r.Get(context.TODO(), finalizerTestAppKey, &appv1beta1.Application{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instanceSub)).To(gomega.Succeed())
+}
+
+func TestFinalizeAppCascadeRefusedForSelectAll(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app, sub, dpl := newFinalizerTestObjects(utils.DeletionPolicyCascade)
+	app.Spec.Selector = &metav1.LabelSelector{}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub, dpl)
+
+	result, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: finalizerTestAppKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.BeZero())
+
+	// the components are orphaned, the application is gone
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, &subv1.Subscription{})).To(gomega.Succeed())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "dpl", Namespace: "app-ns"}, &dplv1.Deployable{})).To(gomega.Succeed())
+
+	err = r.Get(context.TODO(), finalizerTestAppKey, &appv1beta1.Application{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+}
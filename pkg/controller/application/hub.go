@@ -16,11 +16,13 @@ package application
 
 import (
 	"context"
+	"fmt"
 	"strings"
 
 	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
 	"github.com/stolostron/multicloud-operators-application/utils"
-	"k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
@@ -28,12 +30,28 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *ReconcileApplication) doAppHubReconcile(app *appv1beta1.Application) {
+// doAppHubReconcile updates the application annotations and status with its components,
+// and returns the per-cluster deployment of the application.
+// The application is left untouched if any component can't be read, so the last known membership is kept.
+func (r *ReconcileApplication) doAppHubReconcile(app *appv1beta1.Application) ([]utils.ClusterStatus, error) {
 	// allSubs: all subscriptions
 	// allDpls: all deployables. The deployables subscribed in the subscriptions are not counted
 	// allClusterDplMap: all deployables for each cluster. The deployables subscribed in the subscriptions are counted.
 	// All deployables will be required for searching deployed pods
-	allSubs, allDpls, allClusterDplMap := r.GetAllNewDeployablesByApplication(app)
+	namespaces, deniedNamespaces, err := r.getAppNamespaces(app)
+	if err != nil {
+		return nil, err
+	}
+
+	allSubs, allDpls, allClusterDplMap, unresolvedSubs, err := r.GetAllNewDeployablesByApplication(app, namespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	allOthers, err := r.getAllComponentsByApplication(app, namespaces)
+	if err != nil {
+		return nil, err
+	}
 
 	utils.PrintAllClusterDplMap(allClusterDplMap)
 
@@ -60,8 +78,56 @@ func (r *ReconcileApplication) doAppHubReconcile(app *appv1beta1.Application) {
 		app.Annotations = make(map[string]string)
 	}
 
-	app.Annotations["apps.open-cluster-management.io/subscriptions"] = substr
-	app.Annotations["apps.open-cluster-management.io/deployables"] = dplstr
+	if Options.LegacyAnnotations {
+		app.Annotations[utils.AnnotationAppSubscriptions] = substr
+		app.Annotations[utils.AnnotationAppDeployables] = dplstr
+	} else {
+		delete(app.Annotations, utils.AnnotationAppSubscriptions)
+		delete(app.Annotations, utils.AnnotationAppDeployables)
+	}
+
+	updateAppStatus(app, allSubs, allDpls, allOthers)
+
+	app.Annotations[utils.AnnotationMembershipHash] = utils.GetMembershipHash(app.Status.ComponentList)
+	updateAppNamespacesCondition(app, namespaces, deniedNamespaces)
+	utils.SetAppClustersCondition(&app.Status, len(allSubs), unresolvedSubs)
+	utils.RemoveAppCondition(&app.Status, utils.AppConditionReconcileError)
+
+	return utils.GetClusterStatuses(allClusterDplMap), nil
+}
+
+// updateAppStatus fills the application status with the selected components and their aggregated health
+func updateAppStatus(app *appv1beta1.Application, allSubs []*subv1.Subscription, allDpls []*dplv1.Deployable,
+	allOthers []utils.ComponentHealth) {
+	healths := append(utils.GetComponentHealth(allSubs, allDpls), allOthers...)
+	utils.SortComponentHealth(healths)
+
+	app.Status.ObservedGeneration = app.Generation
+	app.Status.ComponentList = utils.GetComponentList(healths)
+
+	ready, total := utils.CountReadyComponents(app.Status.ComponentList)
+	app.Status.ComponentsReady = fmt.Sprintf("%d/%d", ready, total)
+
+	utils.SetAppHealthConditions(&app.Status, healths)
+	utils.SetAppAssemblyPhase(app, healths)
+}
+
+// updateAppNamespacesCondition reports the member namespaces of applications selecting members from other namespaces
+func updateAppNamespacesCondition(app *appv1beta1.Application, namespaces, deniedNamespaces []string) {
+	if !utils.IsCrossNamespaceApp(app) {
+		utils.RemoveAppCondition(&app.Status, utils.AppConditionNamespacesAuthorized)
+		return
+	}
+
+	if len(deniedNamespaces) > 0 {
+		utils.SetAppCondition(&app.Status, utils.AppConditionNamespacesAuthorized, corev1.ConditionFalse, utils.ReasonNamespacesNotAllowed,
+			"Namespaces not allowing the application namespace are skipped: "+strings.Join(deniedNamespaces, ","))
+
+		return
+	}
+
+	utils.SetAppCondition(&app.Status, utils.AppConditionNamespacesAuthorized, corev1.ConditionTrue, utils.ReasonNamespacesAllowed,
+		"Members are selected from namespaces: "+strings.Join(namespaces, ","))
 }
 
 // In 2.5, disable setting the part-of label on all subscriptions of the application.
@@ -70,45 +136,60 @@ func (r *ReconcileApplication) doAppHubReconcile(app *appv1beta1.Application) {
 // Then it is updated to the application name again. so the appsub is updated in a endless loop.
 // application crd/controller will deprecate in 2.6.
 
-// GetAllSubscriptionDeployablesByApplication get all subscriptions and their deployables.app.ibm.com objects by a application
-func (r *ReconcileApplication) GetAllSubscriptionDeployablesByApplication(app *appv1beta1.Application,
-	allClusterDplMap map[string]*utils.DplMap) ([]*subv1.Subscription, error) {
+// GetAllSubscriptionDeployablesByApplication get all subscriptions and their deployables.app.ibm.com objects by a application,
+// and the subscriptions whose managed clusters can't be found
+func (r *ReconcileApplication) GetAllSubscriptionDeployablesByApplication(app *appv1beta1.Application, namespaces []string,
+	allClusterDplMap map[string]*utils.DplMap) ([]*subv1.Subscription, []string, error) {
 	var allSubs []*subv1.Subscription
 
-	subscriptionList := &subv1.SubscriptionList{}
+	var unresolvedSubs []string
 
-	listOptions := &client.ListOptions{Namespace: app.Namespace}
+	var subSelector labels.Selector
 
 	if app.Spec.Selector != nil {
-		subSelector, err := utils.ConvertLabels(app.Spec.Selector)
+		var err error
+
+		subSelector, err = utils.ConvertLabels(app.Spec.Selector)
 		if err != nil {
 			klog.Error("Failed to set label selector of application: ", app.Name, "err: ", err)
 		}
-
-		listOptions.LabelSelector = subSelector
 	}
 
-	err := r.List(context.TODO(), subscriptionList, listOptions)
+	subscriptions, err := r.getMemberSubscriptions(app, namespaces, subSelector)
 	if err != nil {
-		klog.Error("Failed to list subscription objects from application namespace ", app.Namespace, " error: ", err)
-
-		if !errors.IsNotFound(err) {
-			return nil, nil
-		}
+		return nil, nil, err
 	}
 
-	for _, subscription := range subscriptionList.Items {
+	for _, subscription := range subscriptions {
 		allSubs = append(allSubs, subscription.DeepCopy())
 
-		//Check if there is a deployable (its name is "subscrioptionName-deployable") created for deploying the subscription to managed clusters,
+		//The hosting deployable created for deploying the subscription to managed clusters,
 		//the deployable status is used for fetching the managed clusters
-		subdpl := &dplv1.Deployable{}
-		subdplkey := types.NamespacedName{Name: subscription.Name + "-deployable", Namespace: subscription.Namespace}
-		err = r.Get(context.TODO(), subdplkey, subdpl)
-
+		subdpl, err := r.getHostingDeployable(&subscription)
 		if err != nil {
-			klog.V(1).Infof("The deployable created for deploying the subscription not found: subdpl: %#v, error: %#v", subdplkey, err)
-			continue
+			klog.Error("Failed to list the hosting deployable of subscription: ", subscription.Namespace+"/"+subscription.Name, " error: ", err)
+			return nil, nil, err
+		}
+
+		//Without hosting deployable, the managed clusters are decided by the placement the subscription refers to
+		var placementClusters []string
+
+		if subdpl == nil {
+			var found bool
+
+			placementClusters, found, err = r.getPlacementClusters(&subscription)
+			if err != nil {
+				klog.Error("Failed to get the placement decisions of subscription: ", subscription.Namespace+"/"+subscription.Name, " error: ", err)
+				return nil, nil, err
+			}
+
+			if !found {
+				klog.V(1).Info("Neither the hosting deployable nor the placement of the subscription found: ", subscription.Namespace+"/"+subscription.Name)
+
+				unresolvedSubs = append(unresolvedSubs, subscription.Namespace+"/"+subscription.Name)
+
+				continue
+			}
 		}
 
 		dpls := strings.Split(subscription.Annotations[subv1.AnnotationDeployables], ",")
@@ -124,60 +205,110 @@ func (r *ReconcileApplication) GetAllSubscriptionDeployablesByApplication(app *a
 				dplName = strs[1]
 			}
 
-			if dplSpace == "" || dplName == "" {
+			if dplSpace == "" || dplName == "" || !isWatchedNamespace(dplSpace) {
 				continue
 			}
 
-			dpl := &dplv1.Deployable{}
 			dplkey2 := types.NamespacedName{Name: dplName, Namespace: dplSpace}
-			err := r.Get(context.TODO(), dplkey2, dpl)
 
+			dpl, err := r.getMemberDeployable(dplkey2)
 			if err != nil {
 				klog.V(1).Infof("The deployable in the subscription not found: sub: %#v, dpl: %#v, error: %#v", subscription, dplkey2, err)
 				continue
 			}
 
-			utils.AppendClusterDplMap(*subdpl, *dpl, allClusterDplMap)
+			if subdpl != nil {
+				utils.AppendClusterDplMap(*subdpl, *dpl, allClusterDplMap)
+			} else {
+				utils.AppendSubscriptionClusterDplMap(&subscription, placementClusters, *dpl, allClusterDplMap)
+			}
 		}
 	}
 
 	klog.V(1).Infoln("Got all subscriptions in the application: ", app.Name, app.Kind, "|", allSubs)
 
-	return allSubs, nil
+	return allSubs, unresolvedSubs, nil
+}
+
+// getHostingDeployable returns the deployable deploying the subscription to managed clusters, nil if not found.
+// The hosting deployable is found by its subscription owner reference or hosting-subscription annotation.
+func (r *ReconcileApplication) getHostingDeployable(sub *subv1.Subscription) (*dplv1.Deployable, error) {
+	dplList := &dplv1.DeployableList{}
+
+	err := r.List(context.TODO(), dplList, client.MatchingFields{deployableHostingSubscriptionIndex: sub.Namespace + "/" + sub.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dplList.Items) == 0 {
+		return nil, nil
+	}
+
+	if len(dplList.Items) > 1 {
+		klog.Warning("Found more than one hosting deployable of subscription: ", sub.Namespace+"/"+sub.Name, ", using ",
+			dplList.Items[0].Namespace+"/"+dplList.Items[0].Name)
+	}
+
+	return &dplList.Items[0], nil
 }
 
-// GetAllNewDeployablesByApplication get all deployables.app.ibm.com objects by a application
-func (r *ReconcileApplication) GetAllNewDeployablesByApplication(
-	app *appv1beta1.Application) ([]*subv1.Subscription, []*dplv1.Deployable, map[string]*utils.DplMap) {
+// GetAllNewDeployablesByApplication get all deployables.app.ibm.com objects by a application,
+// and the subscriptions whose managed clusters can't be found
+func (r *ReconcileApplication) GetAllNewDeployablesByApplication(app *appv1beta1.Application,
+	namespaces []string) ([]*subv1.Subscription, []*dplv1.Deployable, map[string]*utils.DplMap, []string, error) {
 	var allSubs []*subv1.Subscription
 
+	var unresolvedSubs []string
+
 	var allDpls []*dplv1.Deployable
 
+	var err error
+
 	allClusterDplMap := make(map[string]*utils.DplMap)
 
-	dplList := &dplv1.DeployableList{}
+	if utils.IsAppComponentKind(app, utils.DeployableGroupKind) {
+		allDpls, err = r.getAllDeployablesByApplication(app, namespaces, allClusterDplMap)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	if utils.IsAppComponentKind(app, utils.SubscriptionGroupKind) {
+		allSubs, unresolvedSubs, err = r.GetAllSubscriptionDeployablesByApplication(app, namespaces, allClusterDplMap)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+
+	newAllSubs := utils.GetUniqueSubscriptions(allSubs)
+	newAllDpls := utils.GetUniqueDeployables(allDpls)
+	klog.V(1).Infoln("Got all subscriptions and deployables in the application: ", app.Name, app.Kind, "|", newAllSubs, "|", newAllDpls)
+
+	return newAllSubs, newAllDpls, allClusterDplMap, unresolvedSubs, nil
+}
+
+// getAllDeployablesByApplication get all deployables selected by the application, the generated deployables are skipped
+func (r *ReconcileApplication) getAllDeployablesByApplication(app *appv1beta1.Application, namespaces []string,
+	allClusterDplMap map[string]*utils.DplMap) ([]*dplv1.Deployable, error) {
+	var allDpls []*dplv1.Deployable
 
-	dplListOptions := &client.ListOptions{Namespace: app.Namespace}
+	var clSelector labels.Selector
 
 	if app.Spec.Selector != nil {
-		clSelector, err := utils.ConvertLabels(app.Spec.Selector)
+		var err error
+
+		clSelector, err = utils.ConvertLabels(app.Spec.Selector)
 		if err != nil {
 			klog.Error("Failed to set label selector of application: ", app.Name, "err: ", err)
 		}
-
-		dplListOptions.LabelSelector = clSelector
 	}
 
-	err := r.List(context.TODO(), dplList, dplListOptions)
+	dpls, err := r.getMemberDeployables(app, namespaces, clSelector)
 	if err != nil {
-		klog.Error("Failed to list objects from application namespace ", app.Namespace, " error: ", err)
-
-		if !errors.IsNotFound(err) {
-			return nil, nil, nil
-		}
+		return nil, err
 	}
 
-	for _, dpl := range dplList.Items {
+	for _, dpl := range dpls {
 		if dpl.Annotations != nil && dpl.Annotations[dplv1.AnnotationIsGenerated] == "true" {
 			continue
 		}
@@ -187,11 +318,5 @@ func (r *ReconcileApplication) GetAllNewDeployablesByApplication(
 		allDpls = append(allDpls, dpl.DeepCopy())
 	}
 
-	allSubs, _ = r.GetAllSubscriptionDeployablesByApplication(app, allClusterDplMap)
-
-	newAllSubs := utils.GetUniqueSubscriptions(allSubs)
-	newAllDpls := utils.GetUniqueDeployables(allDpls)
-	klog.V(1).Infoln("Got all subscriptions and deployables in the application: ", app.Name, app.Kind, "|", newAllSubs, "|", newAllDpls)
-
-	return newAllSubs, newAllDpls, allClusterDplMap
+	return allDpls, nil
 }
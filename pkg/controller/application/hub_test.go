@@ -0,0 +1,270 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newHubTestReconciler returns a reconciler on a fake hub, with the cache indexes registered
+func newHubTestReconciler(funcs interceptor.Funcs, objs ...client.Object) *ReconcileApplication {
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&appv1beta1.Application{}).
+		WithIndex(&subv1.Subscription{}, subscriptionChannelNamespaceIndex, subscriptionChannelNamespaceIndexer).
+		WithIndex(&subv1.Subscription{}, subscriptionPlacementRefIndex, subscriptionPlacementRefIndexer).
+		WithIndex(&dplv1.Deployable{}, deployableHostingSubscriptionIndex, deployableHostingSubscriptionIndexer).
+		WithIndex(&subv1.Subscription{}, componentOwnerApplicationIndex, componentOwnerApplicationIndexer).
+		WithIndex(&dplv1.Deployable{}, componentOwnerApplicationIndex, componentOwnerApplicationIndexer).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		WithInterceptorFuncs(funcs).
+		Build()
+
+	return &ReconcileApplication{
+		Client:            clt,
+		scheme:            scheme.Scheme,
+		memberIndex:       newMemberIndex(),
+		publishedClusters: make(map[types.NamespacedName][]utils.ClusterStatus),
+	}
+}
+
+func TestReconcileKeepsMembershipOnListError(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.Annotations = map[string]string{utils.AnnotationAppSubscriptions: "app-ns/sub"}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	listFailing := true
+
+	r := newHubTestReconciler(interceptor.Funcs{
+		List: func(ctx context.Context, clt client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*subv1.SubscriptionList); ok && listFailing {
+				return fmt.Errorf("etcdserver: request timed out")
+			}
+
+			return clt.List(ctx, list, opts...)
+		},
+	}, app, sub)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+
+	// the membership is kept, the failure is reported
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub"))
+
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionReconcileError)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(cond.Message).To(gomega.ContainSubstring("request timed out"))
+
+	// the hub recovers, the condition is cleared
+	listFailing = false
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub"))
+	g.Expect(utils.GetAppCondition(&instance.Status, utils.AppConditionReconcileError)).To(gomega.BeNil())
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.HaveLen(1))
+}
+
+func TestReconcileKeepsConcurrentEdits(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.Finalizers = []string{utils.AppFinalizer}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	// a user edits the application while it is reconciled from the cached copy
+	r := newHubTestReconciler(interceptor.Funcs{
+		Patch: func(ctx context.Context, clt client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			if _, ok := obj.(*appv1beta1.Application); ok {
+				edited := &appv1beta1.Application{}
+				if err := clt.Get(ctx, appKey, edited); err != nil {
+					return err
+				}
+
+				edited.Annotations = map[string]string{"owner": "team-a"}
+				edited.Spec.Descriptor.Description = "edited by a user"
+
+				if err := clt.Update(ctx, edited); err != nil {
+					return err
+				}
+			}
+
+			return clt.Patch(ctx, obj, patch, opts...)
+		},
+	}, app, sub)
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+
+	// both the edit and the controller fields are kept
+	g.Expect(instance.Annotations["owner"]).To(gomega.Equal("team-a"))
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub"))
+	g.Expect(instance.Spec.Descriptor.Description).To(gomega.Equal("edited by a user"))
+	g.Expect(instance.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.Pending))
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.HaveLen(1))
+}
+
+func TestReconcileWritesOnlyOnChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+
+	var subs []client.Object
+	for _, name := range []string{"sub-c", "sub-a", "sub-b"} {
+		subs = append(subs, &subv1.Subscription{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+			Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+		})
+	}
+
+	patches := 0
+
+	r := newHubTestReconciler(interceptor.Funcs{
+		Patch: func(ctx context.Context, clt client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			patches++
+			return clt.Patch(ctx, obj, patch, opts...)
+		},
+		SubResourcePatch: func(ctx context.Context, clt client.Client, subResourceName string, obj client.Object, patch client.Patch,
+			opts ...client.SubResourcePatchOption) error {
+			patches++
+			return clt.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	}, append(subs, app)...)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub-a,app-ns/sub-b,app-ns/sub-c"))
+
+	hash := instance.Annotations[utils.AnnotationMembershipHash]
+	g.Expect(hash).To(gomega.Equal(utils.GetMembershipHash(instance.Status.ComponentList)))
+
+	// the membership is unchanged, nothing is written
+	patches = 0
+
+	for i := 0; i < 3; i++ {
+		_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	g.Expect(patches).To(gomega.BeZero())
+
+	// a subscription leaves the application, the hash changes
+	g.Expect(r.Delete(context.TODO(), subs[0])).To(gomega.Succeed())
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations[utils.AnnotationMembershipHash]).NotTo(gomega.Equal(hash))
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub-a,app-ns/sub-b"))
+}
+
+func TestReconcileRecordsMembershipChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.Finalizers = []string{utils.AppFinalizer}
+	app.Status.ComponentList.Objects = []appv1beta1.ObjectStatus{
+		{Name: "app-ns/old-sub", Kind: "Subscription", Group: "apps.open-cluster-management.io"},
+	}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	var changes []map[string]interface{}
+
+	r := newHubTestReconciler(interceptor.Funcs{
+		SubResourcePatch: func(ctx context.Context, clt client.Client, subResourceName string, obj client.Object, patch client.Patch,
+			opts ...client.SubResourcePatchOption) error {
+			data, err := patch.Data(obj)
+			if err != nil {
+				return err
+			}
+
+			status := map[string]map[string]interface{}{}
+			if err := json.Unmarshal(data, &status); err != nil {
+				return err
+			}
+
+			if change, ok := status["status"]["lastMembershipChange"]; ok {
+				changes = append(changes, change.(map[string]interface{}))
+			}
+
+			return clt.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	}, app, sub)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(changes).To(gomega.HaveLen(1))
+	g.Expect(changes[0]["added"]).To(gomega.Equal(map[string]interface{}{"subscriptions": []interface{}{"app-ns/sub"}}))
+	g.Expect(changes[0]["removed"]).To(gomega.Equal(map[string]interface{}{"subscriptions": []interface{}{"app-ns/old-sub"}}))
+	g.Expect(changes[0]["time"]).NotTo(gomega.BeEmpty())
+
+	// nothing joined or left, no change is written
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changes).To(gomega.HaveLen(1))
+}
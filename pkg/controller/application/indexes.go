@@ -0,0 +1,138 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strings"
+
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// subscriptionChannelNamespaceIndex indexes subscriptions by the namespace of their channel
+	subscriptionChannelNamespaceIndex = "spec.channelNamespace"
+	// subscriptionPlacementRefIndex indexes subscriptions by the "kind/namespace/name" of their placement reference
+	subscriptionPlacementRefIndex = "spec.placement.placementRef"
+	// deployableHostingSubscriptionIndex indexes the hosting deployables by the "namespace/name" of their subscription
+	deployableHostingSubscriptionIndex = "metadata.hostingSubscription"
+	// applicationCrossNamespaceIndex indexes the applications selecting members from other namespaces
+	applicationCrossNamespaceIndex = "metadata.crossNamespace"
+	// componentOwnerApplicationIndex indexes subscriptions and deployables by the name of their owner applications
+	componentOwnerApplicationIndex = "metadata.ownerApplication"
+)
+
+// subscriptionChannelNamespaceIndexer returns the namespace of the subscription channel "namespace/name"
+func subscriptionChannelNamespaceIndexer(obj client.Object) []string {
+	sub, ok := obj.(*subv1.Subscription)
+	if !ok {
+		return nil
+	}
+
+	strs := strings.Split(sub.Spec.Channel, "/")
+	if len(strs) != 2 || strs[0] == "" {
+		return nil
+	}
+
+	return []string{strs[0]}
+}
+
+// subscriptionPlacementRefIndexer returns the placement referred by the subscription
+func subscriptionPlacementRefIndexer(obj client.Object) []string {
+	sub, ok := obj.(*subv1.Subscription)
+	if !ok {
+		return nil
+	}
+
+	refKey := getPlacementRefKey(sub)
+	if refKey == "" {
+		return nil
+	}
+
+	return []string{refKey}
+}
+
+// deployableHostingSubscriptionIndexer returns the subscription deployed by the hosting deployable
+func deployableHostingSubscriptionIndexer(obj client.Object) []string {
+	dpl, ok := obj.(*dplv1.Deployable)
+	if !ok {
+		return nil
+	}
+
+	hosting := utils.GetHostingSubscription(dpl)
+	if hosting == "" {
+		return nil
+	}
+
+	return []string{hosting}
+}
+
+// applicationCrossNamespaceIndexer returns "true" for the applications selecting members from other namespaces
+func applicationCrossNamespaceIndexer(obj client.Object) []string {
+	app, ok := obj.(*appv1beta1.Application)
+	if !ok || !utils.IsCrossNamespaceApp(app) {
+		return nil
+	}
+
+	return []string{"true"}
+}
+
+// componentOwnerApplicationIndexer returns the names of the applications owning the subscription or deployable
+func componentOwnerApplicationIndexer(obj client.Object) []string {
+	var apps []string
+
+	for _, owner := range obj.GetOwnerReferences() {
+		if utils.IsAppOwnerReference(owner, &appv1beta1.Application{ObjectMeta: metav1.ObjectMeta{Name: owner.Name}}) {
+			apps = append(apps, owner.Name)
+		}
+	}
+
+	return apps
+}
+
+// setupIndexes registers the cache indexes used by the mappers
+func setupIndexes(ctx context.Context, indexer client.FieldIndexer) error {
+	err := indexer.IndexField(ctx, &subv1.Subscription{}, subscriptionChannelNamespaceIndex, subscriptionChannelNamespaceIndexer)
+	if err != nil {
+		return err
+	}
+
+	err = indexer.IndexField(ctx, &subv1.Subscription{}, subscriptionPlacementRefIndex, subscriptionPlacementRefIndexer)
+	if err != nil {
+		return err
+	}
+
+	err = indexer.IndexField(ctx, &dplv1.Deployable{}, deployableHostingSubscriptionIndex, deployableHostingSubscriptionIndexer)
+	if err != nil {
+		return err
+	}
+
+	err = indexer.IndexField(ctx, &subv1.Subscription{}, componentOwnerApplicationIndex, componentOwnerApplicationIndexer)
+	if err != nil {
+		return err
+	}
+
+	err = indexer.IndexField(ctx, &dplv1.Deployable{}, componentOwnerApplicationIndex, componentOwnerApplicationIndexer)
+	if err != nil {
+		return err
+	}
+
+	return indexer.IndexField(ctx, &appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer)
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	benchNamespaces    = 500
+	benchSubscriptions = 5000
+)
+
+func newIndexTestObjects(namespaces, subscriptions int) []client.Object {
+	var objs []client.Object
+
+	for i := 0; i < subscriptions; i++ {
+		objs = append(objs, &subv1.Subscription{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sub-%d", i), Namespace: fmt.Sprintf("app-ns-%d", i%namespaces)},
+			Spec:       subv1.SubscriptionSpec{Channel: fmt.Sprintf("chn-ns-%d/chn", i%namespaces)},
+		})
+	}
+
+	for i := 0; i < namespaces; i++ {
+		objs = append(objs, &appv1beta1.Application{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: fmt.Sprintf("app-ns-%d", i)},
+		})
+	}
+
+	return objs
+}
+
+func TestIndexers(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &subv1.Subscription{Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"}}
+	g.Expect(subscriptionChannelNamespaceIndexer(sub)).To(gomega.Equal([]string{"chn-ns"}))
+
+	sub.Spec.Channel = "chn"
+	g.Expect(subscriptionChannelNamespaceIndexer(sub)).To(gomega.BeEmpty())
+
+	dpl := &dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "hosting", Namespace: "sub-ns"}}
+	g.Expect(deployableHostingSubscriptionIndexer(dpl)).To(gomega.BeEmpty())
+
+	dpl.Annotations = map[string]string{dplv1.AnnotationSubscription: "sub-ns/sub"}
+	g.Expect(deployableHostingSubscriptionIndexer(dpl)).To(gomega.Equal([]string{"sub-ns/sub"}))
+
+	app := &appv1beta1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app-ns"}}
+	g.Expect(applicationCrossNamespaceIndexer(app)).To(gomega.BeEmpty())
+
+	app.Annotations = map[string]string{utils.AnnotationMemberNamespaces: "ns1"}
+	g.Expect(applicationCrossNamespaceIndexer(app)).To(gomega.Equal([]string{"true"}))
+}
+
+func TestDeployableMapper(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	crossApp := &appv1beta1.Application{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "cross-app",
+			Namespace:   "other-ns",
+			Annotations: map[string]string{utils.AnnotationMemberNamespaces: "app-ns-1"},
+		},
+	}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(append(newIndexTestObjects(3, 6), crossApp)...).
+		WithIndex(&subv1.Subscription{}, subscriptionChannelNamespaceIndex, subscriptionChannelNamespaceIndexer).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	mapper := &deployableMapper{Client: clt}
+	dpl := &dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "chn-ns-1"}}
+
+	g.Expect(getRequestKeys(mapper.Map(context.TODO(), dpl))).To(gomega.ConsistOf("app-ns-1/app", "other-ns/cross-app"))
+}
+
+// BenchmarkSubscriptionsByChannelNamespace compares the informer index lookup used by the deployable mapper
+// with the full list of subscriptions it replaced
+func BenchmarkSubscriptionsByChannelNamespace(b *testing.B) {
+	indexer := toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		subscriptionChannelNamespaceIndex: func(obj interface{}) ([]string, error) {
+			return subscriptionChannelNamespaceIndexer(obj.(client.Object)), nil
+		},
+	})
+
+	for _, obj := range newIndexTestObjects(benchNamespaces, benchSubscriptions) {
+		if _, ok := obj.(*subv1.Subscription); ok {
+			if err := indexer.Add(obj); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("FullList", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			nsmap := make(map[string]bool)
+
+			for _, obj := range indexer.List() {
+				sub := obj.(*subv1.Subscription).DeepCopy()
+
+				strs := strings.Split(sub.Spec.Channel, "/")
+				if len(strs) == 2 && strs[0] == "chn-ns-1" {
+					nsmap[sub.Namespace] = true
+				}
+			}
+		}
+	})
+
+	b.Run("Indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			nsmap := make(map[string]bool)
+
+			objs, err := indexer.ByIndex(subscriptionChannelNamespaceIndex, "chn-ns-1")
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for _, obj := range objs {
+				nsmap[obj.(*subv1.Subscription).DeepCopy().Namespace] = true
+			}
+		}
+	})
+}
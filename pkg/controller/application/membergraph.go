@@ -0,0 +1,464 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// memberGraph is the in-memory graph of the subscriptions and deployables by namespace, kept current by the watches.
+// It memoizes the members of each application until an event touches a component selected by the application,
+// so a reconcile triggered by anything else reads the members without listing the cache.
+// The objects are shared with the informers and must not be changed.
+type memberGraph struct {
+	// syncLock serializes the syncs
+	syncLock sync.Mutex
+
+	lock   sync.RWMutex
+	synced bool
+	// pending is the events handled while the graph is syncing, nil when not syncing
+	pending []graphEvent
+
+	// subs and dpls are the components by namespace and name
+	subs map[string]map[string]*subv1.Subscription
+	dpls map[string]map[string]*dplv1.Deployable
+
+	// members is the memoized members of each application
+	members map[types.NamespacedName]*appMembers
+	// nsApps is the set of applications whose memoized members come from each namespace
+	nsApps map[string]map[types.NamespacedName]bool
+}
+
+// graphEvent is an event of the subscription and deployable watches
+type graphEvent struct {
+	oldObj  client.Object
+	obj     client.Object
+	deleted bool
+}
+
+// appMembers are the components selected by an application from its member namespaces
+type appMembers struct {
+	namespaces []string
+	selector   labels.Selector
+	subs       []subv1.Subscription
+	dpls       []dplv1.Deployable
+}
+
+func newMemberGraph() *memberGraph {
+	return &memberGraph{
+		subs:    make(map[string]map[string]*subv1.Subscription),
+		dpls:    make(map[string]map[string]*dplv1.Deployable),
+		members: make(map[types.NamespacedName]*appMembers),
+		nsApps:  make(map[string]map[types.NamespacedName]bool),
+	}
+}
+
+// sync fills the graph from the cache once. The events handled while the cache is listed may be older or newer
+// than the listed objects, so they are replayed in order after the listed objects. Newer events of the same objects
+// follow them, and a deleted object is not left in the graph.
+func (mg *memberGraph) sync(ctx context.Context, clt client.Reader) error {
+	mg.lock.RLock()
+	synced := mg.synced
+	mg.lock.RUnlock()
+
+	if synced {
+		return nil
+	}
+
+	mg.syncLock.Lock()
+	defer mg.syncLock.Unlock()
+
+	mg.lock.Lock()
+	if mg.synced {
+		mg.lock.Unlock()
+		return nil
+	}
+
+	mg.pending = []graphEvent{}
+	mg.lock.Unlock()
+
+	subscriptionList := &subv1.SubscriptionList{}
+	dplList := &dplv1.DeployableList{}
+
+	err := clt.List(ctx, subscriptionList)
+	if err == nil {
+		err = clt.List(ctx, dplList)
+	}
+
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+
+	pending := mg.pending
+	mg.pending = nil
+
+	if err != nil {
+		return err
+	}
+
+	for i := range subscriptionList.Items {
+		mg.setSubscription(&subscriptionList.Items[i])
+	}
+
+	for i := range dplList.Items {
+		mg.setDeployable(&dplList.Items[i])
+	}
+
+	for _, e := range pending {
+		mg.apply(e)
+	}
+
+	mg.synced = true
+
+	klog.Info("Member graph synced, subscriptions: ", len(subscriptionList.Items), " deployables: ", len(dplList.Items),
+		" replayed events: ", len(pending))
+
+	return nil
+}
+
+// setObject records the new version of the subscription or deployable
+func (mg *memberGraph) setObject(oldObj, obj client.Object) {
+	mg.record(graphEvent{oldObj: oldObj, obj: obj})
+}
+
+// deleteObject forgets the subscription or deployable
+func (mg *memberGraph) deleteObject(obj client.Object) {
+	mg.record(graphEvent{obj: obj, deleted: true})
+}
+
+// record applies the event, and keeps it to replay if the graph is syncing
+func (mg *memberGraph) record(e graphEvent) {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+
+	mg.apply(e)
+
+	if mg.pending != nil {
+		mg.pending = append(mg.pending, e)
+	}
+}
+
+func (mg *memberGraph) apply(e graphEvent) {
+	switch o := e.obj.(type) {
+	case *subv1.Subscription:
+		if e.deleted {
+			delete(mg.subs[o.Namespace], o.Name)
+		} else {
+			mg.setSubscription(o)
+		}
+	case *dplv1.Deployable:
+		if e.deleted {
+			delete(mg.dpls[o.Namespace], o.Name)
+		} else {
+			mg.setDeployable(o)
+		}
+	default:
+		return
+	}
+
+	if e.oldObj != nil {
+		mg.invalidate(e.oldObj)
+	}
+
+	mg.invalidate(e.obj)
+}
+
+func (mg *memberGraph) setSubscription(sub *subv1.Subscription) {
+	if mg.subs[sub.Namespace] == nil {
+		mg.subs[sub.Namespace] = make(map[string]*subv1.Subscription)
+	}
+
+	mg.subs[sub.Namespace][sub.Name] = sub
+}
+
+func (mg *memberGraph) setDeployable(dpl *dplv1.Deployable) {
+	if mg.dpls[dpl.Namespace] == nil {
+		mg.dpls[dpl.Namespace] = make(map[string]*dplv1.Deployable)
+	}
+
+	mg.dpls[dpl.Namespace][dpl.Name] = dpl
+}
+
+// invalidate drops the memoized members of the applications selecting the object
+func (mg *memberGraph) invalidate(obj client.Object) {
+	for appKey := range mg.nsApps[obj.GetNamespace()] {
+		members := mg.members[appKey]
+		if members != nil && members.selector.Matches(labels.Set(obj.GetLabels())) {
+			klog.V(1).Info("Member graph invalidates application: ", appKey, " on component: ", obj.GetNamespace()+"/"+obj.GetName())
+			mg.forgetLocked(appKey)
+		}
+	}
+}
+
+// forgetApp drops the memoized members of the application
+func (mg *memberGraph) forgetApp(appKey types.NamespacedName) {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+
+	mg.forgetLocked(appKey)
+}
+
+func (mg *memberGraph) forgetLocked(appKey types.NamespacedName) {
+	members, ok := mg.members[appKey]
+	if !ok {
+		return
+	}
+
+	for _, namespace := range members.namespaces {
+		delete(mg.nsApps[namespace], appKey)
+
+		if len(mg.nsApps[namespace]) == 0 {
+			delete(mg.nsApps, namespace)
+		}
+	}
+
+	delete(mg.members, appKey)
+}
+
+// getMembers returns the subscriptions and deployables selected by the application from the namespaces,
+// memoized until an event touches them or the application changes its namespaces or selector
+func (mg *memberGraph) getMembers(appKey types.NamespacedName, namespaces []string, selector labels.Selector) *appMembers {
+	mg.lock.Lock()
+	defer mg.lock.Unlock()
+
+	if members, ok := mg.members[appKey]; ok {
+		if reflect.DeepEqual(members.namespaces, namespaces) && reflect.DeepEqual(members.selector, selector) {
+			return members
+		}
+
+		mg.forgetLocked(appKey)
+	}
+
+	members := &appMembers{namespaces: namespaces, selector: selector}
+
+	for _, namespace := range namespaces {
+		for _, sub := range mg.subs[namespace] {
+			if selector.Matches(labels.Set(sub.Labels)) {
+				members.subs = append(members.subs, *sub)
+			}
+		}
+
+		for _, dpl := range mg.dpls[namespace] {
+			if selector.Matches(labels.Set(dpl.Labels)) {
+				members.dpls = append(members.dpls, *dpl)
+			}
+		}
+
+		if mg.nsApps[namespace] == nil {
+			mg.nsApps[namespace] = make(map[types.NamespacedName]bool)
+		}
+
+		mg.nsApps[namespace][appKey] = true
+	}
+
+	mg.members[appKey] = members
+
+	return members
+}
+
+// getDeployable returns the deployable, nil if not found
+func (mg *memberGraph) getDeployable(key types.NamespacedName) *dplv1.Deployable {
+	mg.lock.RLock()
+	defer mg.lock.RUnlock()
+
+	return mg.dpls[key.Namespace][key.Name]
+}
+
+// getMemberSubscriptions returns the subscriptions selected by the application from the namespaces.
+// They are read from the member graph when there is one, and must not be changed.
+func (r *ReconcileApplication) getMemberSubscriptions(app *appv1beta1.Application, namespaces []string,
+	selector labels.Selector) ([]subv1.Subscription, error) {
+	if r.memberGraph != nil {
+		members, err := r.getGraphMembers(app, namespaces, selector)
+		if err != nil {
+			return nil, err
+		}
+
+		return members.subs, nil
+	}
+
+	var subscriptions []subv1.Subscription
+
+	for _, namespace := range namespaces {
+		subscriptionList := &subv1.SubscriptionList{}
+
+		listOptions := &client.ListOptions{Namespace: namespace, LabelSelector: selector}
+
+		err := r.List(context.TODO(), subscriptionList, listOptions)
+		if err != nil {
+			klog.Error("Failed to list subscription objects from application member namespace ", namespace, " error: ", err)
+
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+
+		subscriptions = append(subscriptions, subscriptionList.Items...)
+	}
+
+	return subscriptions, nil
+}
+
+// getMemberDeployables returns the deployables selected by the application from the namespaces.
+// They are read from the member graph when there is one, and must not be changed.
+func (r *ReconcileApplication) getMemberDeployables(app *appv1beta1.Application, namespaces []string,
+	selector labels.Selector) ([]dplv1.Deployable, error) {
+	if r.memberGraph != nil {
+		members, err := r.getGraphMembers(app, namespaces, selector)
+		if err != nil {
+			return nil, err
+		}
+
+		return members.dpls, nil
+	}
+
+	var dpls []dplv1.Deployable
+
+	for _, namespace := range namespaces {
+		dplList := &dplv1.DeployableList{}
+
+		dplListOptions := &client.ListOptions{Namespace: namespace, LabelSelector: selector}
+
+		err := r.List(context.TODO(), dplList, dplListOptions)
+		if err != nil {
+			klog.Error("Failed to list objects from application member namespace ", namespace, " error: ", err)
+
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+
+		dpls = append(dpls, dplList.Items...)
+	}
+
+	return dpls, nil
+}
+
+// getGraphMembers returns the memoized members of the application, a nil selector selects all components
+func (r *ReconcileApplication) getGraphMembers(app *appv1beta1.Application, namespaces []string,
+	selector labels.Selector) (*appMembers, error) {
+	if err := r.memberGraph.sync(context.TODO(), r.Client); err != nil {
+		return nil, err
+	}
+
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	return r.memberGraph.getMembers(types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, namespaces, selector), nil
+}
+
+// getMemberDeployable returns the deployable, from the member graph when there is one
+func (r *ReconcileApplication) getMemberDeployable(key types.NamespacedName) (*dplv1.Deployable, error) {
+	if r.memberGraph != nil {
+		if err := r.memberGraph.sync(context.TODO(), r.Client); err != nil {
+			return nil, err
+		}
+
+		if dpl := r.memberGraph.getDeployable(key); dpl != nil {
+			return dpl, nil
+		}
+
+		return nil, errors.NewNotFound(dplv1.SchemeGroupVersion.WithResource("deployables").GroupResource(), key.Name)
+	}
+
+	dpl := &dplv1.Deployable{}
+	if err := r.Get(context.TODO(), key, dpl); err != nil {
+		return nil, err
+	}
+
+	return dpl, nil
+}
+
+// trackMembers keeps the member graph current with all events of the watch, the status changes too.
+// The wrapped handler gets the events passing the predicates, after the graph is updated, so the reconciles of
+// the applications it enqueues read the new members.
+func trackMembers[T client.Object](mg *memberGraph, h handler.TypedEventHandler[T, reconcile.Request],
+	predicates ...predicate.TypedPredicate[T]) handler.TypedEventHandler[T, reconcile.Request] {
+	return &memberGraphHandler[T]{graph: mg, handler: h, predicates: predicates}
+}
+
+type memberGraphHandler[T client.Object] struct {
+	graph      *memberGraph
+	handler    handler.TypedEventHandler[T, reconcile.Request]
+	predicates []predicate.TypedPredicate[T]
+}
+
+func (h *memberGraphHandler[T]) Create(ctx context.Context, e event.TypedCreateEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.graph.setObject(nil, e.Object)
+
+	for _, p := range h.predicates {
+		if !p.Create(e) {
+			return
+		}
+	}
+
+	h.handler.Create(ctx, e, q)
+}
+
+func (h *memberGraphHandler[T]) Update(ctx context.Context, e event.TypedUpdateEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.graph.setObject(e.ObjectOld, e.ObjectNew)
+
+	for _, p := range h.predicates {
+		if !p.Update(e) {
+			return
+		}
+	}
+
+	h.handler.Update(ctx, e, q)
+}
+
+func (h *memberGraphHandler[T]) Delete(ctx context.Context, e event.TypedDeleteEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.graph.deleteObject(e.Object)
+
+	for _, p := range h.predicates {
+		if !p.Delete(e) {
+			return
+		}
+	}
+
+	h.handler.Delete(ctx, e, q)
+}
+
+func (h *memberGraphHandler[T]) Generic(ctx context.Context, e event.TypedGenericEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.graph.setObject(nil, e.Object)
+
+	for _, p := range h.predicates {
+		if !p.Generic(e) {
+			return
+		}
+	}
+
+	h.handler.Generic(ctx, e, q)
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestMemberGraph(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	graph := newMemberGraph()
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+	selector := labels.SelectorFromSet(labels.Set{"app": "nginx"})
+
+	mapped := 0
+	h := trackMembers(graph, handler.TypedFuncs[*dplv1.Deployable, reconcile.Request]{
+		UpdateFunc: func(context.Context, event.TypedUpdateEvent[*dplv1.Deployable], workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			mapped++
+		},
+	}, utils.DeployablePredicateFunc)
+
+	template := &runtime.RawExtension{Raw: []byte(`{"apiVersion":"v1","kind":"ConfigMap"}`)}
+
+	dpl := &dplv1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       dplv1.DeployableSpec{Template: template},
+	}
+	other := &dplv1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "app-ns", Labels: map[string]string{"app": "redis"}},
+		Spec:       dplv1.DeployableSpec{Template: template},
+	}
+
+	h.Create(context.TODO(), event.TypedCreateEvent[*dplv1.Deployable]{Object: dpl}, nil)
+	h.Create(context.TODO(), event.TypedCreateEvent[*dplv1.Deployable]{Object: other}, nil)
+
+	members := graph.getMembers(appKey, []string{"app-ns"}, selector)
+	g.Expect(members.dpls).To(gomega.HaveLen(1))
+	g.Expect(members.dpls[0].Name).To(gomega.Equal("dpl"))
+
+	// memoized until a selected component changes
+	g.Expect(graph.getMembers(appKey, []string{"app-ns"}, selector)).To(gomega.BeIdenticalTo(members))
+
+	otherNew := other.DeepCopy()
+	otherNew.Status.Phase = dplv1.DeployableDeployed
+	h.Update(context.TODO(), event.TypedUpdateEvent[*dplv1.Deployable]{ObjectOld: other, ObjectNew: otherNew}, nil)
+	g.Expect(graph.getMembers(appKey, []string{"app-ns"}, selector)).To(gomega.BeIdenticalTo(members))
+
+	// a status change is filtered by the predicate, but still reaches the graph
+	dplNew := dpl.DeepCopy()
+	dplNew.Status.Phase = dplv1.DeployableDeployed
+	h.Update(context.TODO(), event.TypedUpdateEvent[*dplv1.Deployable]{ObjectOld: dpl, ObjectNew: dplNew}, nil)
+
+	newMembers := graph.getMembers(appKey, []string{"app-ns"}, selector)
+	g.Expect(newMembers).NotTo(gomega.BeIdenticalTo(members))
+	g.Expect(newMembers.dpls[0].Status.Phase).To(gomega.Equal(dplv1.DeployableDeployed))
+	g.Expect(graph.getDeployable(types.NamespacedName{Name: "dpl", Namespace: "app-ns"})).To(gomega.BeIdenticalTo(dplNew))
+
+	// a component leaving the selector
+	dplLeft := dplNew.DeepCopy()
+	dplLeft.Labels = map[string]string{"app": "redis"}
+	h.Update(context.TODO(), event.TypedUpdateEvent[*dplv1.Deployable]{ObjectOld: dplNew, ObjectNew: dplLeft}, nil)
+	g.Expect(graph.getMembers(appKey, []string{"app-ns"}, selector).dpls).To(gomega.BeEmpty())
+	g.Expect(mapped).To(gomega.Equal(1))
+
+	h.Delete(context.TODO(), event.TypedDeleteEvent[*dplv1.Deployable]{Object: dplLeft}, nil)
+	g.Expect(graph.getDeployable(types.NamespacedName{Name: "dpl", Namespace: "app-ns"})).To(gomega.BeNil())
+
+	graph.forgetApp(appKey)
+	g.Expect(graph.members).To(gomega.BeEmpty())
+	g.Expect(graph.nsApps).To(gomega.BeEmpty())
+}
+
+func TestReconcileReadsMemberGraph(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	lists := 0
+	r := newHubTestReconciler(interceptor.Funcs{
+		List: func(ctx context.Context, clt client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+			if _, ok := list.(*subv1.SubscriptionList); ok {
+				lists++
+			}
+
+			return clt.List(ctx, list, opts...)
+		},
+	}, app, sub)
+	r.memberGraph = newMemberGraph()
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.HaveLen(1))
+
+	// the graph is filled once, the next reconciles read the memoized members
+	listed := lists
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(lists).To(gomega.Equal(listed))
+
+	// the watch event of the deleted subscription updates the members
+	g.Expect(r.Delete(context.TODO(), sub)).To(gomega.Succeed())
+	r.memberGraph.deleteObject(sub)
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.BeEmpty())
+}
+
+func TestMemberGraphSyncReplaysEvents(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	graph := newMemberGraph()
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+	selector := labels.SelectorFromSet(labels.Set{"app": "nginx"})
+
+	deleted := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "deleted", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+	}
+	updated := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "updated", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+	}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(deleted, updated).
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, clt client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+				if err := clt.List(ctx, list, opts...); err != nil {
+					return err
+				}
+
+				if _, ok := list.(*subv1.SubscriptionList); ok {
+					// the watch events handled after the list, before the graph is filled
+					graph.deleteObject(deleted)
+
+					updatedNew := updated.DeepCopy()
+					updatedNew.Labels = map[string]string{"app": "redis"}
+					graph.setObject(updated, updatedNew)
+				}
+
+				return nil
+			},
+		}).Build()
+
+	g.Expect(graph.sync(context.TODO(), clt)).To(gomega.Succeed())
+
+	g.Expect(graph.getMembers(appKey, []string{"app-ns"}, selector).subs).To(gomega.BeEmpty())
+	g.Expect(graph.subs["app-ns"]).To(gomega.HaveLen(1))
+	g.Expect(graph.subs["app-ns"]["updated"].Labels).To(gomega.Equal(map[string]string{"app": "redis"}))
+	g.Expect(graph.pending).To(gomega.BeNil())
+}
@@ -0,0 +1,488 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// maxReportedOverlaps caps the number of shared components named in the OverlappingMembership condition
+const maxReportedOverlaps = 5
+
+// memberIndex is the reverse index from the application components to the applications selecting them.
+// It holds the applications of the shards owned by the replica, all applications when not sharded. The applications
+// of a shard are seeded from their component lists when the shard is acquired, then kept current by the reconciles.
+type memberIndex struct {
+	lock sync.Mutex
+	// seeded is the set of seeded shards, shard 0 when not sharded
+	seeded map[int]bool
+	// owners is the set of applications "namespace/name" of each component "group/kind/namespace/name"
+	owners map[string]map[string]bool
+	// members is the set of components of each application
+	members map[string]map[string]bool
+}
+
+func newMemberIndex() *memberIndex {
+	return &memberIndex{
+		seeded:  make(map[int]bool),
+		owners:  make(map[string]map[string]bool),
+		members: make(map[string]map[string]bool),
+	}
+}
+
+// setAppMembers replaces the components of the application, nil to forget the application.
+// It returns the components joining or leaving the application.
+func (idx *memberIndex) setAppMembers(app string, components []string) []string {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	return idx.setAppMembersLocked(app, components)
+}
+
+// getUnseededShards returns the owned shards not seeded yet, and the seeded shards no longer owned
+func (idx *memberIndex) getUnseededShards(owned []int) ([]int, []int) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	var unseeded, lost []int
+
+	ownedSet := make(map[int]bool, len(owned))
+
+	for _, shard := range owned {
+		ownedSet[shard] = true
+
+		if !idx.seeded[shard] {
+			unseeded = append(unseeded, shard)
+		}
+	}
+
+	for shard := range idx.seeded {
+		if !ownedSet[shard] {
+			lost = append(lost, shard)
+		}
+	}
+
+	return unseeded, lost
+}
+
+// seed records the components of the applications of the shards, the applications already set by a reconcile are kept
+func (idx *memberIndex) seed(apps map[string][]string, shards []int) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	for app, components := range apps {
+		if _, ok := idx.members[app]; !ok {
+			idx.setAppMembersLocked(app, components)
+		}
+	}
+
+	for _, shard := range shards {
+		idx.seeded[shard] = true
+	}
+}
+
+// forgetShards drops the applications of the shards, their owners are read from the components again
+func (idx *memberIndex) forgetShards(shards []int, shardOf func(app string) int) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	forgotten := make(map[int]bool, len(shards))
+	for _, shard := range shards {
+		forgotten[shard] = true
+
+		delete(idx.seeded, shard)
+	}
+
+	for app := range idx.members {
+		if forgotten[shardOf(app)] {
+			idx.setAppMembersLocked(app, nil)
+		}
+	}
+}
+
+func (idx *memberIndex) setAppMembersLocked(app string, components []string) []string {
+	oldMembers := idx.members[app]
+	newMembers := make(map[string]bool, len(components))
+
+	var changed []string
+
+	for _, component := range components {
+		newMembers[component] = true
+
+		if oldMembers[component] {
+			continue
+		}
+
+		if idx.owners[component] == nil {
+			idx.owners[component] = make(map[string]bool)
+		}
+
+		idx.owners[component][app] = true
+
+		changed = append(changed, component)
+	}
+
+	for component := range oldMembers {
+		if newMembers[component] {
+			continue
+		}
+
+		delete(idx.owners[component], app)
+
+		if len(idx.owners[component]) == 0 {
+			delete(idx.owners, component)
+		}
+
+		changed = append(changed, component)
+	}
+
+	if len(newMembers) == 0 {
+		delete(idx.members, app)
+	} else {
+		idx.members[app] = newMembers
+	}
+
+	sort.Strings(changed)
+
+	return changed
+}
+
+// getOwners returns the sorted applications selecting the component
+func (idx *memberIndex) getOwners(component string) []string {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	owners := make([]string, 0, len(idx.owners[component]))
+	for owner := range idx.owners[component] {
+		owners = append(owners, owner)
+	}
+
+	sort.Strings(owners)
+
+	return owners
+}
+
+// getComponentKeys returns the "group/kind/namespace/name" of the components in the component list
+func getComponentKeys(components []appv1beta1.ObjectStatus) []string {
+	keys := make([]string, 0, len(components))
+	for _, component := range components {
+		keys = append(keys, getStatusComponentKey(component))
+	}
+
+	return keys
+}
+
+// getStatusComponentKey returns the "group/kind/namespace/name" of the component, the component name is "namespace/name"
+func getStatusComponentKey(component appv1beta1.ObjectStatus) string {
+	return component.Group + "/" + component.Kind + "/" + component.Name
+}
+
+// getAppShard returns the shard of the application "namespace/name", 0 when not sharded
+func (r *ReconcileApplication) getAppShard(app string) int {
+	if r.shards == nil {
+		return 0
+	}
+
+	namespace, _, _ := strings.Cut(app, "/")
+
+	return getNamespaceShard(namespace, r.shards.shards)
+}
+
+// getOwnedShards returns the shards owned by the replica, shard 0 when not sharded
+func (r *ReconcileApplication) getOwnedShards() []int {
+	if r.shards == nil {
+		return []int{0}
+	}
+
+	var owned []int
+
+	for shard := 0; shard < r.shards.shards; shard++ {
+		if r.shards.ownsShard(shard) {
+			owned = append(owned, shard)
+		}
+	}
+
+	return owned
+}
+
+// seedMemberIndex seeds the member index from the component lists of the applications of the shards owned by the replica,
+// before the first owner applications annotation is written. Otherwise the annotations written right after a restart or
+// a shard handover would miss the applications not reconciled yet. The applications of the shards no longer owned are
+// dropped, their owners are read from the owner applications annotations.
+func (r *ReconcileApplication) seedMemberIndex(ctx context.Context) error {
+	unseeded, lost := r.memberIndex.getUnseededShards(r.getOwnedShards())
+
+	if len(lost) > 0 {
+		r.memberIndex.forgetShards(lost, r.getAppShard)
+	}
+
+	if len(unseeded) == 0 {
+		return nil
+	}
+
+	shards := make(map[int]bool, len(unseeded))
+	for _, shard := range unseeded {
+		shards[shard] = true
+	}
+
+	applicationList := &appv1beta1.ApplicationList{}
+	if err := r.List(ctx, applicationList); err != nil {
+		return err
+	}
+
+	apps := make(map[string][]string, len(applicationList.Items))
+
+	for i := range applicationList.Items {
+		app := &applicationList.Items[i]
+
+		if app.GetDeletionTimestamp() != nil || !shards[r.getAppShard(app.Namespace+"/"+app.Name)] {
+			continue
+		}
+
+		components := app.Status.ComponentList.Objects
+
+		if len(components) == 0 && isSpilledApp(app) {
+			spilled, err := r.getSpilledComponents(ctx, app)
+			if err != nil {
+				return err
+			}
+
+			components = spilled
+		}
+
+		apps[app.Namespace+"/"+app.Name] = getComponentKeys(components)
+	}
+
+	r.memberIndex.seed(apps, unseeded)
+
+	klog.Info("Member index seeded, shards: ", unseeded, " applications: ", len(apps))
+
+	return nil
+}
+
+// syncMemberOwners records the components of the application in the member index, nil for a deleted application.
+// The subscriptions and deployables joining or leaving the application get their owner applications annotation updated.
+// It returns the components selected by other applications too, with those applications.
+func (r *ReconcileApplication) syncMemberOwners(ctx context.Context, appKey types.NamespacedName,
+	components []appv1beta1.ObjectStatus) (map[string][]string, error) {
+	if err := r.seedMemberIndex(ctx); err != nil {
+		return nil, err
+	}
+
+	keys := getComponentKeys(components)
+
+	changed := r.memberIndex.setAppMembers(appKey.String(), keys)
+
+	for _, key := range changed {
+		if err := r.patchOwnersAnnotation(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	overlaps := make(map[string][]string)
+
+	for _, key := range keys {
+		var others []string
+
+		owners, err := r.getComponentOwners(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, owner := range owners {
+			if owner != appKey.String() {
+				others = append(others, owner)
+			}
+		}
+
+		if len(others) > 0 {
+			overlaps[key] = others
+		}
+	}
+
+	return overlaps, nil
+}
+
+// getComponentOwners returns the sorted applications selecting the component. When sharded, the owners of the other
+// shards are read from the owner applications annotation of the subscription or deployable.
+func (r *ReconcileApplication) getComponentOwners(ctx context.Context, key string) ([]string, error) {
+	if r.shards == nil {
+		return r.memberIndex.getOwners(key), nil
+	}
+
+	obj := newComponentObject(key)
+	if obj == nil {
+		return r.getShardedOwners(key, ""), nil
+	}
+
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	return r.getShardedOwners(key, obj.GetAnnotations()[utils.AnnotationAppOwners]), nil
+}
+
+// patchOwnersAnnotation writes the applications selecting the subscription or deployable to its owner applications annotation.
+// Only the annotation is patched, the other fields are left to the subscription controller.
+func (r *ReconcileApplication) patchOwnersAnnotation(ctx context.Context, key string) error {
+	obj := newComponentObject(key)
+	if obj == nil {
+		return nil
+	}
+
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	oldOwners, ok := obj.GetAnnotations()[utils.AnnotationAppOwners]
+
+	owners := strings.Join(r.getShardedOwners(key, oldOwners), ",")
+	if oldOwners == owners && (ok || owners == "") {
+		return nil
+	}
+
+	var value interface{}
+	if owners != "" {
+		value = owners
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{utils.AnnotationAppOwners: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	klog.V(1).Info("Updating owner applications of component: ", key, " owners: ", owners)
+
+	err = r.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(fieldManager))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// getShardedOwners returns the sorted applications selecting the component. The member index only holds the applications
+// of the shards owned by the replica, the owners of the other shards are kept from the annotation.
+func (r *ReconcileApplication) getShardedOwners(key, oldOwners string) []string {
+	if r.shards == nil {
+		return r.memberIndex.getOwners(key)
+	}
+
+	var owners []string
+
+	for _, owner := range r.memberIndex.getOwners(key) {
+		if namespace, _, _ := strings.Cut(owner, "/"); r.shards.ownsNamespace(namespace) {
+			owners = append(owners, owner)
+		}
+	}
+
+	for _, owner := range strings.Split(oldOwners, ",") {
+		if namespace, _, ok := strings.Cut(owner, "/"); ok && !r.shards.ownsNamespace(namespace) {
+			owners = append(owners, owner)
+		}
+	}
+
+	sort.Strings(owners)
+
+	return owners
+}
+
+// setOverlappingMembershipCondition reports the components selected by other applications too
+func setOverlappingMembershipCondition(app *appv1beta1.Application, overlaps map[string][]string) {
+	if len(overlaps) == 0 {
+		utils.RemoveAppCondition(&app.Status, utils.AppConditionOverlappingMembership)
+		return
+	}
+
+	keys := make([]string, 0, len(overlaps))
+	for key := range overlaps {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var msgs []string
+
+	for i, key := range keys {
+		if i == maxReportedOverlaps {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(keys)-maxReportedOverlaps))
+			break
+		}
+
+		strs := strings.SplitN(key, "/", 3)
+		msgs = append(msgs, strs[1]+" "+strs[2]+" is also selected by "+strings.Join(overlaps[key], ","))
+	}
+
+	utils.SetAppCondition(&app.Status, utils.AppConditionOverlappingMembership, corev1.ConditionTrue,
+		utils.ReasonComponentsShared, strings.Join(msgs, "; "))
+}
+
+// removeFromOwnersAnnotation removes the application from the owner applications annotation of the component,
+// tells if the annotation is changed
+func removeFromOwnersAnnotation(obj client.Object, app *appv1beta1.Application) bool {
+	annotations := obj.GetAnnotations()
+
+	value, ok := annotations[utils.AnnotationAppOwners]
+	if !ok {
+		return false
+	}
+
+	appKey := app.Namespace + "/" + app.Name
+
+	var owners []string
+
+	for _, owner := range strings.Split(value, ",") {
+		if owner != "" && owner != appKey {
+			owners = append(owners, owner)
+		}
+	}
+
+	if strings.Join(owners, ",") == value {
+		return false
+	}
+
+	if len(owners) == 0 {
+		delete(annotations, utils.AnnotationAppOwners)
+	} else {
+		annotations[utils.AnnotationAppOwners] = strings.Join(owners, ",")
+	}
+
+	obj.SetAnnotations(annotations)
+
+	return true
+}
@@ -0,0 +1,225 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestMemberIndex(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	idx := newMemberIndex()
+
+	sub1 := "apps.open-cluster-management.io/Subscription/ns/sub1"
+	sub2 := "apps.open-cluster-management.io/Subscription/ns/sub2"
+	dpl1 := "apps.open-cluster-management.io/Deployable/ns/dpl1"
+
+	g.Expect(idx.setAppMembers("ns/app-a", []string{sub1, sub2})).To(gomega.Equal([]string{sub1, sub2}))
+	g.Expect(idx.setAppMembers("ns/app-b", []string{sub2})).To(gomega.Equal([]string{sub2}))
+	g.Expect(idx.getOwners(sub2)).To(gomega.Equal([]string{"ns/app-a", "ns/app-b"}))
+
+	// only the components joining or leaving are returned
+	g.Expect(idx.setAppMembers("ns/app-a", []string{sub2, dpl1})).To(gomega.Equal([]string{dpl1, sub1}))
+	g.Expect(idx.getOwners(sub1)).To(gomega.BeEmpty())
+
+	g.Expect(idx.setAppMembers("ns/app-b", nil)).To(gomega.Equal([]string{sub2}))
+	g.Expect(idx.getOwners(sub2)).To(gomega.Equal([]string{"ns/app-a"}))
+	g.Expect(idx.members).NotTo(gomega.HaveKey("ns/app-b"))
+
+	// a kind of the same name in another group is another component
+	g.Expect(idx.setAppMembers("ns/app-c", []string{"other.io/Subscription/ns/sub2"})).To(
+		gomega.Equal([]string{"other.io/Subscription/ns/sub2"}))
+	g.Expect(idx.getOwners(sub2)).To(gomega.Equal([]string{"ns/app-a"}))
+
+	// the seed keeps the applications already set by a reconcile
+	unseeded, lost := idx.getUnseededShards([]int{0, 1})
+	g.Expect(unseeded).To(gomega.Equal([]int{0, 1}))
+	g.Expect(lost).To(gomega.BeEmpty())
+
+	idx.seed(map[string][]string{"ns/app-a": {sub1}, "ns/app-d": {sub2}}, []int{0, 1})
+	g.Expect(idx.getOwners(sub1)).To(gomega.BeEmpty())
+	g.Expect(idx.getOwners(sub2)).To(gomega.Equal([]string{"ns/app-a", "ns/app-d"}))
+
+	// the applications of a lost shard are dropped
+	unseeded, lost = idx.getUnseededShards([]int{0})
+	g.Expect(unseeded).To(gomega.BeEmpty())
+	g.Expect(lost).To(gomega.Equal([]int{1}))
+
+	idx.forgetShards(lost, func(app string) int {
+		if app == "ns/app-d" {
+			return 1
+		}
+
+		return 0
+	})
+	g.Expect(idx.getOwners(sub2)).To(gomega.Equal([]string{"ns/app-a"}))
+
+	unseeded, _ = idx.getUnseededShards([]int{0, 1})
+	g.Expect(unseeded).To(gomega.Equal([]int{1}))
+}
+
+func TestReconcileSeedsMemberIndex(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx", "tier": "web"},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	appA := newEnqueueTestApp("app-a", "app-ns", map[string]string{"app": "nginx"})
+
+	// app-b was reconciled by the previous controller, its component list names the subscription
+	appB := newEnqueueTestApp("app-b", "app-ns", map[string]string{"tier": "web"})
+	appB.Status.ComponentList.Objects = []appv1beta1.ObjectStatus{utils.GetSubscriptionHealth(sub).ObjectStatus}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, appA, appB, sub)
+
+	keyA := types.NamespacedName{Name: "app-a", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: keyA})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the first reconcile after the restart already writes both owners
+	instanceSub := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/app-a,app-ns/app-b"))
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), keyA, instance)).To(gomega.Succeed())
+
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Message).To(gomega.Equal("Subscription app-ns/sub is also selected by app-ns/app-b"))
+}
+
+func TestReconcileShardedMemberIndex(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// other-ns is in another shard than app-ns
+	otherNs := ""
+	for i := 0; otherNs == ""; i++ {
+		if ns := fmt.Sprintf("other-ns-%d", i); getNamespaceShard(ns, 4) != getNamespaceShard("app-ns", 4) {
+			otherNs = ns
+		}
+	}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"},
+			Annotations: map[string]string{utils.AnnotationAppOwners: otherNs + "/app-b"},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	appA := newEnqueueTestApp("app-a", "app-ns", map[string]string{"app": "nginx"})
+
+	// app-c is in a shard of another replica, its stale component list is not seeded
+	appC := newEnqueueTestApp("app-c", otherNs, map[string]string{"app": "nginx"})
+	appC.Status.ComponentList.Objects = []appv1beta1.ObjectStatus{utils.GetSubscriptionHealth(sub).ObjectStatus}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, appA, appC, sub)
+	r.shards = newShardManager(r.Client, r.Client, "controller-ns", 4, 30*time.Second)
+	r.shards.renewed[getNamespaceShard("app-ns", 4)] = time.Now()
+
+	keyA := types.NamespacedName{Name: "app-a", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: keyA})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.memberIndex.members).NotTo(gomega.HaveKey(otherNs + "/app-c"))
+
+	// the owner of the other shard is kept from the annotation
+	instanceSub := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/app-a," + otherNs + "/app-b"))
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), keyA, instance)).To(gomega.Succeed())
+
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Message).To(gomega.Equal("Subscription app-ns/sub is also selected by " + otherNs + "/app-b"))
+}
+
+func TestReconcileOverlappingMembership(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	appA := newEnqueueTestApp("app-a", "app-ns", map[string]string{"app": "nginx"})
+	appB := newEnqueueTestApp("app-b", "app-ns", map[string]string{"tier": "web"})
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx", "tier": "web"},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, appA, appB, sub)
+
+	keyA := types.NamespacedName{Name: "app-a", Namespace: "app-ns"}
+	keyB := types.NamespacedName{Name: "app-b", Namespace: "app-ns"}
+	subKey := types.NamespacedName{Name: "sub", Namespace: "app-ns"}
+
+	// the annotation update of the subscription enqueues both applications again
+	for _, key := range []types.NamespacedName{keyA, keyB, keyA} {
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	instanceSub := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), subKey, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/app-a,app-ns/app-b"))
+
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), keyA, instance)).To(gomega.Succeed())
+
+	cond := utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(cond.Message).To(gomega.Equal("Subscription app-ns/sub is also selected by app-ns/app-b"))
+
+	g.Expect(r.Get(context.TODO(), keyB, instance)).To(gomega.Succeed())
+	g.Expect(utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)).NotTo(gomega.BeNil())
+
+	// app-b no longer selects the subscription
+	instance.Spec.Selector.MatchLabels = map[string]string{"tier": "db"}
+	g.Expect(r.Update(context.TODO(), instance)).To(gomega.Succeed())
+
+	for _, key := range []types.NamespacedName{keyB, keyA} {
+		_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	g.Expect(r.Get(context.TODO(), subKey, instanceSub)).To(gomega.Succeed())
+	g.Expect(instanceSub.Annotations[utils.AnnotationAppOwners]).To(gomega.Equal("app-ns/app-a"))
+
+	g.Expect(r.Get(context.TODO(), keyA, instance)).To(gomega.Succeed())
+	g.Expect(utils.GetAppCondition(&instance.Status, utils.AppConditionOverlappingMembership)).To(gomega.BeNil())
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// membersConfigMapSuffix names the companion ConfigMap of the application, "<application>-members"
+	membersConfigMapSuffix = "-members"
+	// membersConfigMapSubscriptionsKey lists the subscriptions of the application in the companion ConfigMap, one per line
+	membersConfigMapSubscriptionsKey = "subscriptions"
+	// membersConfigMapDeployablesKey lists the deployables of the application in the companion ConfigMap, one per line
+	membersConfigMapDeployablesKey = "deployables"
+	// membersConfigMapComponentsKey holds the status.components of the application in the companion ConfigMap, as json
+	membersConfigMapComponentsKey = "components"
+)
+
+// getAPIReader returns the reader bypassing the cache, the client if there is none
+func (r *ReconcileApplication) getAPIReader() client.Reader {
+	if r.apiReader != nil {
+		return r.apiReader
+	}
+
+	return r.Client
+}
+
+// spillAppMembers moves the subscriptions and deployables annotations and status.components of the application to its
+// companion ConfigMap, when together they are larger than Options.MembershipAnnotationLimit. The application keeps the
+// name of the ConfigMap and a summary of the members in its annotations, and the ready count in status.componentsReady.
+// The status.components written to the application is then empty, see getStoredAppStatus.
+// The ConfigMap is deleted once the members fit in the application again.
+func (r *ReconcileApplication) spillAppMembers(ctx context.Context, app *appv1beta1.Application) error {
+	annotations := app.GetAnnotations()
+	substr := annotations[utils.AnnotationAppSubscriptions]
+	dplstr := annotations[utils.AnnotationAppDeployables]
+	name := app.Name + membersConfigMapSuffix
+
+	components, err := json.Marshal(app.Status.ComponentList.Objects)
+	if err != nil {
+		return err
+	}
+
+	size := len(substr) + len(dplstr) + len(components)
+
+	if Options.MembershipAnnotationLimit <= 0 || size <= Options.MembershipAnnotationLimit {
+		if _, ok := annotations[utils.AnnotationMembersConfigMap]; !ok {
+			return nil
+		}
+
+		delete(annotations, utils.AnnotationMembersConfigMap)
+		delete(annotations, utils.AnnotationMembersSummary)
+
+		return r.deleteMembersConfigMap(ctx, app, name)
+	}
+
+	data := map[string]string{
+		membersConfigMapSubscriptionsKey: strings.ReplaceAll(substr, ",", "\n"),
+		membersConfigMapDeployablesKey:   strings.ReplaceAll(dplstr, ",", "\n"),
+		membersConfigMapComponentsKey:    string(components),
+	}
+
+	if err := r.writeMembersConfigMap(ctx, app, name, data); err != nil {
+		return err
+	}
+
+	delete(annotations, utils.AnnotationAppSubscriptions)
+	delete(annotations, utils.AnnotationAppDeployables)
+
+	annotations[utils.AnnotationMembersConfigMap] = name
+	annotations[utils.AnnotationMembersSummary] = getMembersSummary(app.Status.ComponentList.Objects)
+
+	return nil
+}
+
+// getMembersSummary counts the components, and the subscriptions and deployables among them
+func getMembersSummary(components []appv1beta1.ObjectStatus) string {
+	subs, dpls := 0, 0
+
+	for _, component := range components {
+		switch (metav1.GroupKind{Group: component.Group, Kind: component.Kind}) {
+		case utils.SubscriptionGroupKind:
+			subs++
+		case utils.DeployableGroupKind:
+			dpls++
+		}
+	}
+
+	return fmt.Sprintf("%d components, %d subscriptions, %d deployables", len(components), subs, dpls)
+}
+
+// isSpilledApp tells if the members of the application are in its companion ConfigMap
+func isSpilledApp(app *appv1beta1.Application) bool {
+	return app.GetAnnotations()[utils.AnnotationMembersConfigMap] != ""
+}
+
+// getStoredAppStatus returns the status written to the application, without the components spilled to the ConfigMap
+func getStoredAppStatus(app *appv1beta1.Application, status *appv1beta1.ApplicationStatus) *appv1beta1.ApplicationStatus {
+	if !isSpilledApp(app) {
+		return status
+	}
+
+	stored := status.DeepCopy()
+	stored.ComponentList = appv1beta1.ComponentList{}
+
+	return stored
+}
+
+// loadSpilledComponents fills the empty status.components of the spilled application from its companion ConfigMap,
+// so the application compares to its new status as if it held its components. It tells if the components are loaded.
+func (r *ReconcileApplication) loadSpilledComponents(ctx context.Context, app *appv1beta1.Application) (bool, error) {
+	if !isSpilledApp(app) || len(app.Status.ComponentList.Objects) > 0 {
+		return false, nil
+	}
+
+	components, err := r.getSpilledComponents(ctx, app)
+	if err != nil {
+		return false, err
+	}
+
+	app.Status.ComponentList.Objects = components
+
+	return true, nil
+}
+
+// getSpilledComponents reads the status.components of the application from its companion ConfigMap, nil if there is none
+func (r *ReconcileApplication) getSpilledComponents(ctx context.Context, app *appv1beta1.Application) ([]appv1beta1.ObjectStatus, error) {
+	name := app.GetAnnotations()[utils.AnnotationMembersConfigMap]
+	if name == "" {
+		return nil, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, configMap)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	data := configMap.Data[membersConfigMapComponentsKey]
+	if !metav1.IsControlledBy(configMap, app) || data == "" {
+		return nil, nil
+	}
+
+	var components []appv1beta1.ObjectStatus
+	if err := json.Unmarshal([]byte(data), &components); err != nil {
+		klog.Error("Failed to read the components from the members ConfigMap of application: ", app.Namespace+"/"+app.Name,
+			" error: ", err)
+		return nil, nil
+	}
+
+	return components, nil
+}
+
+// writeMembersConfigMap creates or updates the companion ConfigMap controlled by the application
+func (r *ReconcileApplication) writeMembersConfigMap(ctx context.Context, app *appv1beta1.Application, name string,
+	data map[string]string) error {
+	configMap := &corev1.ConfigMap{}
+
+	// read from the apiserver, the ConfigMaps of the cluster are not cached
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, configMap)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: name},
+			Data:       data,
+		}
+
+		if err := controllerutil.SetControllerReference(app, configMap, r.scheme); err != nil {
+			return err
+		}
+
+		klog.Info("Creating the members ConfigMap of application: ", app.Namespace+"/"+app.Name)
+
+		return r.Create(ctx, configMap, client.FieldOwner(fieldManager))
+	}
+
+	if !metav1.IsControlledBy(configMap, app) {
+		return fmt.Errorf("ConfigMap %s/%s is not controlled by application %s", app.Namespace, name, app.Name)
+	}
+
+	if reflect.DeepEqual(configMap.Data, data) {
+		return nil
+	}
+
+	configMap.Data = data
+
+	klog.V(1).Info("Updating the members ConfigMap of application: ", app.Namespace+"/"+app.Name)
+
+	return r.Update(ctx, configMap, client.FieldOwner(fieldManager))
+}
+
+// deleteMembersConfigMap deletes the companion ConfigMap if it is controlled by the application
+func (r *ReconcileApplication) deleteMembersConfigMap(ctx context.Context, app *appv1beta1.Application, name string) error {
+	configMap := &corev1.ConfigMap{}
+
+	err := r.getAPIReader().Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, configMap)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if !metav1.IsControlledBy(configMap, app) {
+		return nil
+	}
+
+	klog.Info("Deleting the members ConfigMap of application: ", app.Namespace+"/"+app.Name)
+
+	err = r.Delete(ctx, configMap)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
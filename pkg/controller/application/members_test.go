@@ -0,0 +1,177 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSpillAppMembers(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(limit int) { Options.MembershipAnnotationLimit = limit }(Options.MembershipAnnotationLimit)
+
+	Options.MembershipAnnotationLimit = 1
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+
+	var subs []client.Object
+	for _, name := range []string{"sub-a", "sub-b", "sub-c"} {
+		subs = append(subs, &subv1.Subscription{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+			Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+		})
+	}
+
+	statusPatches, membershipChanges := 0, 0
+	r := newHubTestReconciler(interceptor.Funcs{
+		SubResourcePatch: func(ctx context.Context, clt client.Client, subResourceName string, obj client.Object,
+			patch client.Patch, opts ...client.SubResourcePatchOption) error {
+			statusPatches++
+
+			if data, _ := patch.Data(obj); strings.Contains(string(data), "lastMembershipChange") {
+				membershipChanges++
+			}
+
+			return clt.SubResource(subResourceName).Patch(ctx, obj, patch, opts...)
+		},
+	}, append(subs, app)...)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+	configMapKey := types.NamespacedName{Name: "app-members", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the application keeps a pointer and a summary, the members are in the ConfigMap
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations).NotTo(gomega.HaveKey(utils.AnnotationAppSubscriptions))
+	g.Expect(instance.Annotations[utils.AnnotationMembersConfigMap]).To(gomega.Equal("app-members"))
+	g.Expect(instance.Annotations[utils.AnnotationMembersSummary]).To(gomega.Equal("3 components, 3 subscriptions, 0 deployables"))
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.BeEmpty())
+	g.Expect(instance.Status.ComponentsReady).To(gomega.Equal("0/3"))
+
+	configMap := &corev1.ConfigMap{}
+	g.Expect(r.Get(context.TODO(), configMapKey, configMap)).To(gomega.Succeed())
+	g.Expect(configMap.Data[membersConfigMapSubscriptionsKey]).To(gomega.Equal("app-ns/sub-a\napp-ns/sub-b\napp-ns/sub-c"))
+	g.Expect(metav1.IsControlledBy(configMap, instance)).To(gomega.BeTrue())
+
+	components, err := r.getSpilledComponents(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(components).To(gomega.HaveLen(3))
+	g.Expect(components[0].Name).To(gomega.Equal("app-ns/sub-a"))
+
+	// an unchanged spilled application is not written again
+	patches := statusPatches
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(statusPatches).To(gomega.Equal(patches))
+
+	// the members fit in the application again, the ConfigMap is deleted, the membership is unchanged
+	Options.MembershipAnnotationLimit = 128 * 1024
+	changes := membershipChanges
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations[utils.AnnotationAppSubscriptions]).To(gomega.Equal("app-ns/sub-a,app-ns/sub-b,app-ns/sub-c"))
+	g.Expect(instance.Annotations).NotTo(gomega.HaveKey(utils.AnnotationMembersConfigMap))
+	g.Expect(instance.Annotations).NotTo(gomega.HaveKey(utils.AnnotationMembersSummary))
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.HaveLen(3))
+	g.Expect(membershipChanges).To(gomega.Equal(changes))
+
+	err = r.Get(context.TODO(), configMapKey, &corev1.ConfigMap{})
+	g.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+}
+
+func TestSpillAppComponentsWithoutLegacyAnnotations(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(limit int, legacy bool) {
+		Options.MembershipAnnotationLimit, Options.LegacyAnnotations = limit, legacy
+	}(Options.MembershipAnnotationLimit, Options.LegacyAnnotations)
+
+	Options.MembershipAnnotationLimit = 1
+	Options.LegacyAnnotations = false
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// status.components is spilled even without the annotations
+	instance := &appv1beta1.Application{}
+	g.Expect(r.Get(context.TODO(), appKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.Annotations[utils.AnnotationMembersConfigMap]).To(gomega.Equal("app-members"))
+	g.Expect(instance.Status.ComponentList.Objects).To(gomega.BeEmpty())
+
+	components, err := r.getSpilledComponents(context.TODO(), instance)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(components).To(gomega.HaveLen(1))
+}
+
+func TestSpillAppMembersKeepsForeignConfigMap(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(limit int) { Options.MembershipAnnotationLimit = limit }(Options.MembershipAnnotationLimit)
+
+	Options.MembershipAnnotationLimit = 1
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"}},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	// a ConfigMap of the same name, not created by the controller
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-members", Namespace: "app-ns"},
+		Data:       map[string]string{"key": "value"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub, configMap)
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "app-ns"}})
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "app-members", Namespace: "app-ns"}, configMap)).To(gomega.Succeed())
+	g.Expect(configMap.Data).To(gomega.Equal(map[string]string{"key": "value"}))
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// isWatchedNamespace tells if the namespace is watched by the controller
+func isWatchedNamespace(namespace string) bool {
+	if len(Options.WatchNamespaces) == 0 {
+		return true
+	}
+
+	for _, watched := range Options.WatchNamespaces {
+		if watched == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getAppNamespaces returns the namespaces the application selects members from, sorted, and the requested namespaces
+// that don't allow the application. The application namespace always comes first. The namespaces not watched by the
+// controller are skipped.
+func (r *ReconcileApplication) getAppNamespaces(app *appv1beta1.Application) ([]string, []string, error) {
+	namespaces := []string{app.Namespace}
+
+	if !utils.IsCrossNamespaceApp(app) {
+		return namespaces, nil, nil
+	}
+
+	requested := make(map[string]*corev1.Namespace)
+
+	for _, nsName := range strings.Split(app.Annotations[utils.AnnotationMemberNamespaces], ",") {
+		nsName = strings.TrimSpace(nsName)
+		if nsName == "" || nsName == app.Namespace || !isWatchedNamespace(nsName) {
+			continue
+		}
+
+		ns := &corev1.Namespace{}
+
+		err := r.Get(context.TODO(), types.NamespacedName{Name: nsName}, ns)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				klog.Error("Failed to get member namespace of application: ", app.Namespace+"/"+app.Name, " namespace: ", nsName, " err: ", err)
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		requested[nsName] = ns
+	}
+
+	if selectorStr := app.Annotations[utils.AnnotationMemberNamespaceSelector]; selectorStr != "" {
+		selector, err := labels.Parse(selectorStr)
+		if err != nil {
+			klog.Error("Failed to parse member namespace selector of application: ", app.Namespace+"/"+app.Name, " err: ", err)
+		} else {
+			nsList := &corev1.NamespaceList{}
+
+			err = r.List(context.TODO(), nsList, &client.ListOptions{LabelSelector: selector})
+			if err != nil {
+				klog.Error("Failed to list member namespaces of application: ", app.Namespace+"/"+app.Name, " err: ", err)
+				return nil, nil, err
+			}
+
+			for i := range nsList.Items {
+				if nsList.Items[i].Name != app.Namespace && isWatchedNamespace(nsList.Items[i].Name) {
+					requested[nsList.Items[i].Name] = &nsList.Items[i]
+				}
+			}
+		}
+	}
+
+	var allowed, denied []string
+
+	for nsName, ns := range requested {
+		if utils.IsAppNamespaceAllowed(ns, app.Namespace) {
+			allowed = append(allowed, nsName)
+		} else {
+			denied = append(denied, nsName)
+		}
+	}
+
+	sort.Strings(allowed)
+	sort.Strings(denied)
+
+	return append(namespaces, allowed...), denied, nil
+}
+
+type namespaceMapper struct {
+	client.Client
+}
+
+func (mapper *namespaceMapper) Map(ctx context.Context, obj *corev1.Namespace) []reconcile.Request {
+	//enqueue all applications selecting members from other namespaces, the namespace may join or leave them
+	klog.V(1).Info("In namespace Mapper:", obj.GetName())
+
+	return listCrossNamespaceApps(mapper.Client)
+}
+
+// listCrossNamespaceApps returns the requests of all applications selecting members from other namespaces
+func listCrossNamespaceApps(clt client.Client) []reconcile.Request {
+	var requests []reconcile.Request
+
+	applicationList := &appv1beta1.ApplicationList{}
+
+	err := clt.List(context.TODO(), applicationList, client.MatchingFields{applicationCrossNamespaceIndex: "true"})
+	if err != nil {
+		klog.Error("Failed to list all application objects. ", "error: ", err)
+		return requests
+	}
+
+	for i := range applicationList.Items {
+		app := &applicationList.Items[i]
+
+		objkey := types.NamespacedName{
+			Name:      app.GetName(),
+			Namespace: app.GetNamespace(),
+		}
+
+		requests = append(requests, reconcile.Request{NamespacedName: objkey})
+	}
+
+	return requests
+}
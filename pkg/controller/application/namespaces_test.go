@@ -0,0 +1,65 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestGetAppNamespacesWatchNamespaces(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(namespaces []string) { Options.WatchNamespaces = namespaces }(Options.WatchNamespaces)
+
+	Options.WatchNamespaces = []string{"app-ns", "ns1"}
+
+	g.Expect(isWatchedNamespace("ns1")).To(gomega.BeTrue())
+	g.Expect(isWatchedNamespace("ns2")).To(gomega.BeFalse())
+
+	app := newEnqueueTestApp("app", "app-ns", nil)
+	app.Annotations = map[string]string{utils.AnnotationMemberNamespaces: "ns1,ns2"}
+
+	var objs []*corev1.Namespace
+	for _, name := range []string{"ns1", "ns2"} {
+		objs = append(objs, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{utils.AnnotationAllowedAppNamespaces: "*"},
+		}})
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, objs[0], objs[1])
+
+	namespaces, denied, err := r.getAppNamespaces(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(namespaces).To(gomega.Equal([]string{"app-ns", "ns1"}))
+	g.Expect(denied).To(gomega.BeEmpty())
+
+	// the applications of the namespaces not watched are not listed
+	finder := newMemberAppFinder(r.Client)
+	g.Expect(finder.listApps("app-ns")).To(gomega.HaveLen(1))
+	g.Expect(finder.listApps("ns2")).To(gomega.BeEmpty())
+
+	Options.WatchNamespaces = nil
+
+	namespaces, _, err = r.getAppNamespaces(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(namespaces).To(gomega.Equal([]string{"app-ns", "ns1", "ns2"}))
+}
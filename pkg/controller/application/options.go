@@ -0,0 +1,62 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import "time"
+
+// ControllerOptions for the application controller.
+type ControllerOptions struct {
+	// LegacyAnnotations keeps the comma-joined subscriptions/deployables annotations on the application
+	// for consumers that have not moved to the application status yet.
+	LegacyAnnotations bool
+	// MembershipAnnotationLimit is the size in bytes of the subscriptions/deployables annotations and status.components
+	// above which the members are written to a companion ConfigMap instead. The annotations of an object are limited
+	// to 256KiB in total.
+	MembershipAnnotationLimit int
+	// Discovery generates an application for each subscription no application selects.
+	// The generated applications are deleted when their last subscription is gone.
+	Discovery bool
+	// WatchNamespaces are the only namespaces the controller watches and reads members from, all namespaces when empty.
+	// The manager cache is restricted to the same namespaces.
+	WatchNamespaces []string
+	// Shards spreads the applications over the live replicas by the hash of their namespace, coordinated through leases.
+	// Every replica is active and reconciles the applications of the shards it owns. Not sharded when less than 2.
+	Shards int
+	// ShardLeaseDuration is how long a shard stays with a replica that stopped renewing its lease
+	ShardLeaseDuration time.Duration
+	// MaxConcurrentReconciles is the number of applications reconciled at the same time
+	MaxConcurrentReconciles int
+	// BackoffBase and BackoffMax bound the exponential backoff of a failing application
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// QPS and Burst limit the reconciles of all applications together, triggered by the watches or retried
+	QPS   float64
+	Burst int
+	// EnqueueDebounce delays the reconciles triggered by the components, so their changes within the delay
+	// are coalesced into one reconcile per application. Not delayed when zero.
+	EnqueueDebounce time.Duration
+}
+
+// Options is set by the manager command before the controller is added to the manager.
+var Options = ControllerOptions{
+	LegacyAnnotations:         true,
+	MembershipAnnotationLimit: 128 * 1024,
+	ShardLeaseDuration:        30 * time.Second,
+	MaxConcurrentReconciles:   1,
+	BackoffBase:               5 * time.Millisecond,
+	BackoffMax:                1000 * time.Second,
+	QPS:                       10,
+	Burst:                     100,
+}
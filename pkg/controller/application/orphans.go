@@ -0,0 +1,235 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// orphanReportPeriod is how often the orphaned components are reported
+	orphanReportPeriod = 5 * time.Minute
+	// orphanReportName names the ConfigMap of the orphaned components report, in the controller namespace
+	orphanReportName = "application-orphaned-components"
+	// orphanReportTruncatedKey counts the orphaned components left out of the report, no namespace starts with a dot
+	orphanReportTruncatedKey = ".truncated"
+	// maxOrphanReportBytes caps the listed components, well below the 1MiB limit of a ConfigMap
+	maxOrphanReportBytes = 512 * 1024
+	// podNamespaceEnvVar is the controller namespace
+	podNamespaceEnvVar = "POD_NAMESPACE"
+)
+
+// orphanedComponentsGauge counts the subscriptions and deployables selected by no application
+var orphanedComponentsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "application_orphaned_components",
+	Help: "Number of subscriptions and deployables selected by no application, by namespace and kind",
+}, []string{"namespace", "kind"})
+
+func init() {
+	metrics.Registry.MustRegister(orphanedComponentsGauge)
+}
+
+// orphanReporter periodically reports the subscriptions and non-generated deployables selected by no application,
+// in the application_orphaned_components metric and the report ConfigMap of the controller namespace.
+type orphanReporter struct {
+	client.Client
+	// apiReader reads the report ConfigMap, the ConfigMaps of the cluster are not cached
+	apiReader client.Reader
+	// namespace of the report ConfigMap, no report object if empty
+	namespace string
+	// shards reports through the replica owning the first shard, nil when not sharded
+	shards *shardManager
+}
+
+func newOrphanReporter(clt client.Client, apiReader client.Reader) *orphanReporter {
+	return &orphanReporter{
+		Client:    clt,
+		apiReader: apiReader,
+		namespace: os.Getenv(podNamespaceEnvVar),
+	}
+}
+
+// Start runs the report until the context is done, only on the leader. When sharded, every replica runs it and
+// the replica owning the first shard reports.
+func (o *orphanReporter) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := o.report(ctx); err != nil {
+			klog.Error("Failed to report the orphaned components, error: ", err)
+		}
+	}, orphanReportPeriod)
+
+	return nil
+}
+
+// report updates the metric and the report ConfigMap with the orphaned components
+func (o *orphanReporter) report(ctx context.Context) error {
+	if !o.shards.ownsShard(0) {
+		orphanedComponentsGauge.Reset()
+		return nil
+	}
+
+	orphans, err := o.getOrphanedComponents(ctx)
+	if err != nil {
+		return err
+	}
+
+	orphanedComponentsGauge.Reset()
+
+	for namespace, components := range orphans {
+		counts := make(map[string]int)
+
+		for _, component := range components {
+			kind, _, _ := strings.Cut(component, " ")
+			counts[kind]++
+		}
+
+		for kind, count := range counts {
+			orphanedComponentsGauge.WithLabelValues(namespace, kind).Set(float64(count))
+		}
+	}
+
+	klog.V(1).Info("Orphaned components found in ", len(orphans), " namespaces")
+
+	if o.namespace == "" {
+		return nil
+	}
+
+	return o.writeReport(ctx, getOrphanReport(orphans))
+}
+
+// getOrphanReport returns the report data, one key per namespace listing its orphaned components. The namespaces are
+// listed in order until maxOrphanReportBytes, the count of the components left out is kept in orphanReportTruncatedKey.
+func getOrphanReport(orphans map[string][]string) map[string]string {
+	namespaces := make([]string, 0, len(orphans))
+	for namespace := range orphans {
+		namespaces = append(namespaces, namespace)
+	}
+
+	sort.Strings(namespaces)
+
+	data := make(map[string]string)
+	size := 0
+	truncated := 0
+
+	for _, namespace := range namespaces {
+		components := orphans[namespace]
+		listed := 0
+		nsSize := len(namespace)
+
+		for listed < len(components) && size+nsSize+len(components[listed])+1 <= maxOrphanReportBytes {
+			nsSize += len(components[listed]) + 1
+			listed++
+		}
+
+		if listed > 0 {
+			data[namespace] = strings.Join(components[:listed], "\n")
+			size += nsSize
+		}
+
+		truncated += len(components) - listed
+	}
+
+	if truncated > 0 {
+		data[orphanReportTruncatedKey] = strconv.Itoa(truncated)
+	}
+
+	return data
+}
+
+// getOrphanedComponents lists the subscriptions and non-generated deployables selected by no application,
+// as sorted "Kind name" by namespace. An application of another namespace the component namespace doesn't allow
+// doesn't select the component.
+func (o *orphanReporter) getOrphanedComponents(ctx context.Context) (map[string][]string, error) {
+	orphans := make(map[string][]string)
+	finder := newMemberAppFinder(o.Client)
+
+	subscriptionList := &subv1.SubscriptionList{}
+	if err := o.List(ctx, subscriptionList); err != nil {
+		return nil, err
+	}
+
+	for _, sub := range subscriptionList.Items {
+		if len(finder.getAuthorizedSelectingApps(utils.SubscriptionGroupKind, sub.Namespace, sub.Labels)) == 0 {
+			orphans[sub.Namespace] = append(orphans[sub.Namespace], utils.SubscriptionGroupKind.Kind+" "+sub.Name)
+		}
+	}
+
+	dplList := &dplv1.DeployableList{}
+	if err := o.List(ctx, dplList); err != nil {
+		return nil, err
+	}
+
+	for _, dpl := range dplList.Items {
+		if dpl.Annotations[dplv1.AnnotationIsGenerated] == "true" {
+			continue
+		}
+
+		if len(finder.getAuthorizedSelectingApps(utils.DeployableGroupKind, dpl.Namespace, dpl.Labels)) == 0 {
+			orphans[dpl.Namespace] = append(orphans[dpl.Namespace], utils.DeployableGroupKind.Kind+" "+dpl.Name)
+		}
+	}
+
+	for namespace := range orphans {
+		sort.Strings(orphans[namespace])
+	}
+
+	return orphans, nil
+}
+
+// writeReport creates or updates the report ConfigMap, one key per namespace listing its orphaned components
+func (o *orphanReporter) writeReport(ctx context.Context, data map[string]string) error {
+	configMap := &corev1.ConfigMap{}
+
+	err := o.apiReader.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: orphanReportName}, configMap)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: o.namespace, Name: orphanReportName},
+			Data:       data,
+		}
+
+		return o.Create(ctx, configMap, client.FieldOwner(fieldManager))
+	}
+
+	if reflect.DeepEqual(configMap.Data, data) || len(configMap.Data)+len(data) == 0 {
+		return nil
+	}
+
+	configMap.Data = data
+
+	return o.Update(ctx, configMap, client.FieldOwner(fieldManager))
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOrphanReporter(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	nginxLabels := map[string]string{"app": "nginx"}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(
+			newEnqueueTestApp("nginx", "app-ns", nginxLabels),
+			&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "nginx-sub", Namespace: "app-ns", Labels: nginxLabels}},
+			&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "forgotten-sub", Namespace: "app-ns"}},
+			&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "other-sub", Namespace: "other-ns", Labels: nginxLabels}},
+			&dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "nginx-dpl", Namespace: "app-ns", Labels: nginxLabels}},
+			&dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "forgotten-dpl", Namespace: "app-ns"}},
+			&dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{
+				Name: "generated-dpl", Namespace: "app-ns", Annotations: map[string]string{dplv1.AnnotationIsGenerated: "true"},
+			}},
+		).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	reporter := newOrphanReporter(clt, clt)
+	reporter.namespace = "controller-ns"
+
+	g.Expect(reporter.report(context.TODO())).To(gomega.Succeed())
+
+	report := &corev1.ConfigMap{}
+	g.Expect(clt.Get(context.TODO(), types.NamespacedName{Name: orphanReportName, Namespace: "controller-ns"}, report)).To(gomega.Succeed())
+	g.Expect(report.Data).To(gomega.Equal(map[string]string{
+		"app-ns":   "Deployable forgotten-dpl\nSubscription forgotten-sub",
+		"other-ns": "Subscription other-sub",
+	}))
+
+	g.Expect(testutil.ToFloat64(orphanedComponentsGauge.WithLabelValues("app-ns", "Subscription"))).To(gomega.Equal(1.0))
+	g.Expect(testutil.ToFloat64(orphanedComponentsGauge.WithLabelValues("app-ns", "Deployable"))).To(gomega.Equal(1.0))
+	g.Expect(testutil.ToFloat64(orphanedComponentsGauge.WithLabelValues("other-ns", "Subscription"))).To(gomega.Equal(1.0))
+
+	// the forgotten subscription is wrapped in an application
+	g.Expect(clt.Create(context.TODO(), newEnqueueTestApp("forgotten", "other-ns", nginxLabels))).To(gomega.Succeed())
+	g.Expect(reporter.report(context.TODO())).To(gomega.Succeed())
+
+	g.Expect(clt.Get(context.TODO(), types.NamespacedName{Name: orphanReportName, Namespace: "controller-ns"}, report)).To(gomega.Succeed())
+	g.Expect(report.Data).NotTo(gomega.HaveKey("other-ns"))
+	g.Expect(testutil.CollectAndCount(orphanedComponentsGauge)).To(gomega.Equal(2))
+}
+
+func TestOrphanReporterDeniedNamespace(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	nginxLabels := map[string]string{"app": "nginx"}
+
+	crossApp := newEnqueueTestApp("cross", "tenant-ns", nginxLabels)
+	crossApp.Annotations = map[string]string{utils.AnnotationMemberNamespaces: "allowed-ns,denied-ns"}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(
+			crossApp,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "allowed-ns", Annotations: map[string]string{utils.AnnotationAllowedAppNamespaces: "tenant-ns"},
+			}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "denied-ns"}},
+			&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "allowed-sub", Namespace: "allowed-ns", Labels: nginxLabels}},
+			&subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "denied-sub", Namespace: "denied-ns", Labels: nginxLabels}},
+		).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	reporter := newOrphanReporter(clt, clt)
+
+	// the application can't select the subscription of the namespace denying it
+	orphans, err := reporter.getOrphanedComponents(context.TODO())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(orphans).To(gomega.Equal(map[string][]string{"denied-ns": {"Subscription denied-sub"}}))
+}
+
+func TestOrphanReporterTruncatesReport(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// 3000 subscriptions of long names, over 600KiB of report
+	objs := make([]client.Object, 0, 3000)
+	for i := 0; i < 3000; i++ {
+		name := fmt.Sprintf("%s-%04d", strings.Repeat("orphan", 33), i)
+		objs = append(objs, &subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: fmt.Sprintf("ns-%d", i%3)}})
+	}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(objs...).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	reporter := newOrphanReporter(clt, clt)
+	reporter.namespace = "controller-ns"
+
+	g.Expect(reporter.report(context.TODO())).To(gomega.Succeed())
+
+	report := &corev1.ConfigMap{}
+	g.Expect(clt.Get(context.TODO(), types.NamespacedName{Name: orphanReportName, Namespace: "controller-ns"}, report)).To(gomega.Succeed())
+
+	size := 0
+	listed := 0
+
+	for key, value := range report.Data {
+		if key != orphanReportTruncatedKey {
+			size += len(key) + len(value)
+			listed += len(strings.Split(value, "\n"))
+		}
+	}
+
+	g.Expect(size).To(gomega.BeNumerically("<=", maxOrphanReportBytes))
+	g.Expect(report.Data).To(gomega.HaveKey("ns-0"))
+
+	truncated, err := strconv.Atoi(report.Data[orphanReportTruncatedKey])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(truncated).To(gomega.BeNumerically(">", 0))
+	g.Expect(listed + truncated).To(gomega.Equal(3000))
+
+	// the metric still counts all orphaned components
+	g.Expect(testutil.ToFloat64(orphanedComponentsGauge.WithLabelValues("ns-2", "Subscription"))).To(gomega.Equal(1000.0))
+}
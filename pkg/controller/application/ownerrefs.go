@@ -0,0 +1,223 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jsonPatchOperation is one operation of a json patch
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// syncAppOwnerReferences adds a non-controller owner reference to the application on the subscriptions and deployables
+// of the component list when spec.addOwnerRef is set, and removes it from the objects that are no longer components.
+// Owner references can't cross namespaces, so only the components in the application namespace are referenced.
+// The references are patched one by one, the other owner references are left to their owners, e.g. the subscription controller.
+func (r *ReconcileApplication) syncAppOwnerReferences(ctx context.Context, app *appv1beta1.Application,
+	components []appv1beta1.ObjectStatus) error {
+	if app.UID == "" {
+		return nil
+	}
+
+	members := make(map[string]bool)
+
+	if app.Spec.AddOwnerRef {
+		for _, component := range components {
+			members[getStatusComponentKey(component)] = true
+		}
+	}
+
+	owned, err := r.listAppOwnedComponents(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range owned {
+		if members[getComponentKey(obj)] {
+			delete(members, getComponentKey(obj))
+			continue
+		}
+
+		if err := r.patchOwnerReferences(ctx, obj, getRemoveOwnerReferenceOps(obj, app)); err != nil {
+			return err
+		}
+	}
+
+	for key := range members {
+		obj := newComponentObject(key)
+		if obj == nil || obj.GetNamespace() != app.Namespace {
+			continue
+		}
+
+		err := r.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, obj)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if err := r.patchOwnerReferences(ctx, obj, getAddOwnerReferenceOps(obj, app)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listAppOwnedComponents lists the subscriptions and deployables referring to the application as owner
+func (r *ReconcileApplication) listAppOwnedComponents(ctx context.Context, app *appv1beta1.Application) ([]client.Object, error) {
+	var owned []client.Object
+
+	listOptions := []client.ListOption{client.InNamespace(app.Namespace), client.MatchingFields{componentOwnerApplicationIndex: app.Name}}
+
+	subscriptionList := &subv1.SubscriptionList{}
+	if err := r.List(ctx, subscriptionList, listOptions...); err != nil {
+		return nil, err
+	}
+
+	for i := range subscriptionList.Items {
+		owned = append(owned, &subscriptionList.Items[i])
+	}
+
+	dplList := &dplv1.DeployableList{}
+	if err := r.List(ctx, dplList, listOptions...); err != nil {
+		return nil, err
+	}
+
+	for i := range dplList.Items {
+		owned = append(owned, &dplList.Items[i])
+	}
+
+	return owned, nil
+}
+
+// patchOwnerReferences applies the owner reference operations to the component
+func (r *ReconcileApplication) patchOwnerReferences(ctx context.Context, obj client.Object, ops []jsonPatchOperation) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	klog.V(1).Info("Patching owner references of component: ", obj.GetNamespace()+"/"+obj.GetName(), " patch: ", string(patch))
+
+	err = r.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// getAddOwnerReferenceOps appends a non-controller owner reference to the application, unless the object has one
+func getAddOwnerReferenceOps(obj client.Object, app *appv1beta1.Application) []jsonPatchOperation {
+	for _, owner := range obj.GetOwnerReferences() {
+		if utils.IsAppOwnerReference(owner, app) {
+			return nil
+		}
+	}
+
+	ref := metav1.OwnerReference{
+		APIVersion: appv1beta1.GroupVersion.String(),
+		Kind:       "Application",
+		Name:       app.Name,
+		UID:        app.UID,
+	}
+
+	if len(obj.GetOwnerReferences()) == 0 {
+		// the list is created, make sure no owner reference was added in the meantime
+		return []jsonPatchOperation{
+			{Op: "test", Path: "/metadata/resourceVersion", Value: obj.GetResourceVersion()},
+			{Op: "add", Path: "/metadata/ownerReferences", Value: []metav1.OwnerReference{ref}},
+		}
+	}
+
+	return []jsonPatchOperation{{Op: "add", Path: "/metadata/ownerReferences/-", Value: ref}}
+}
+
+// getRemoveOwnerReferenceOps removes the owner references to the application, each one checked by its uid first
+func getRemoveOwnerReferenceOps(obj client.Object, app *appv1beta1.Application) []jsonPatchOperation {
+	var ops []jsonPatchOperation
+
+	owners := obj.GetOwnerReferences()
+
+	// remove from the end, so the index of the other references doesn't move
+	for i := len(owners) - 1; i >= 0; i-- {
+		if !utils.IsAppOwnerReference(owners[i], app) {
+			continue
+		}
+
+		path := fmt.Sprintf("/metadata/ownerReferences/%d", i)
+
+		ops = append(ops,
+			jsonPatchOperation{Op: "test", Path: path + "/uid", Value: owners[i].UID},
+			jsonPatchOperation{Op: "remove", Path: path})
+	}
+
+	return ops
+}
+
+// getComponentKey returns "group/kind/namespace/name" of the subscription or deployable, as in the application component list
+func getComponentKey(obj client.Object) string {
+	switch obj.(type) {
+	case *subv1.Subscription:
+		return utils.SubscriptionGroupKind.Group + "/" + utils.SubscriptionGroupKind.Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+	case *dplv1.Deployable:
+		return utils.DeployableGroupKind.Group + "/" + utils.DeployableGroupKind.Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+	}
+
+	return ""
+}
+
+// newComponentObject returns an empty subscription or deployable for the component key, nil for the other kinds
+func newComponentObject(key string) client.Object {
+	strs := strings.SplitN(key, "/", 4)
+	if len(strs) != 4 {
+		return nil
+	}
+
+	meta := metav1.ObjectMeta{Namespace: strs[2], Name: strs[3]}
+
+	switch (metav1.GroupKind{Group: strs[0], Kind: strs[1]}) {
+	case utils.SubscriptionGroupKind:
+		return &subv1.Subscription{ObjectMeta: meta}
+	case utils.DeployableGroupKind:
+		return &dplv1.Deployable{ObjectMeta: meta}
+	}
+
+	return nil
+}
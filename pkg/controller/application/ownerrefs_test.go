@@ -0,0 +1,114 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	"github.com/stolostron/multicloud-operators-application/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestSyncAppOwnerReferences(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.UID = "app-uid"
+	app.Spec.AddOwnerRef = true
+
+	// the subscription controller owns the subscription too, its reference must be kept
+	channelRef := metav1.OwnerReference{APIVersion: "apps.open-cluster-management.io/v1", Kind: "Channel", Name: "chn", UID: "chn-uid"}
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"},
+			OwnerReferences: []metav1.OwnerReference{channelRef},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	unlabeledSub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "unlabeled-sub", Namespace: "app-ns"},
+		Spec:       subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub, unlabeledSub)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+	subKey := types.NamespacedName{Name: "sub", Namespace: "app-ns"}
+
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), subKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.OwnerReferences).To(gomega.HaveLen(2))
+	g.Expect(instance.OwnerReferences[0]).To(gomega.Equal(channelRef))
+	g.Expect(utils.IsAppOwnerReference(instance.OwnerReferences[1], app)).To(gomega.BeTrue())
+	g.Expect(instance.OwnerReferences[1].Controller).To(gomega.BeNil())
+
+	unlabeled := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "unlabeled-sub", Namespace: "app-ns"}, unlabeled)).To(gomega.Succeed())
+	g.Expect(unlabeled.OwnerReferences).To(gomega.BeEmpty())
+
+	// the reconcile is idempotent
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.Get(context.TODO(), subKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.OwnerReferences).To(gomega.HaveLen(2))
+
+	// the subscription leaves the application, the reference is removed
+	instance.Labels = nil
+	g.Expect(r.Update(context.TODO(), instance)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: appKey})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(r.Get(context.TODO(), subKey, instance)).To(gomega.Succeed())
+	g.Expect(instance.OwnerReferences).To(gomega.Equal([]metav1.OwnerReference{channelRef}))
+}
+
+func TestSyncAppOwnerReferencesDisabled(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := newEnqueueTestApp("app", "app-ns", map[string]string{"app": "nginx"})
+	app.UID = "app-uid"
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "sub", Namespace: "app-ns", Labels: map[string]string{"app": "nginx"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: appv1beta1.GroupVersion.String(), Kind: "Application", Name: "app", UID: "app-uid"},
+			},
+		},
+		Spec: subv1.SubscriptionSpec{Channel: "chn-ns/chn"},
+	}
+
+	r := newHubTestReconciler(interceptor.Funcs{}, app, sub)
+
+	// addOwnerRef is off, the reference set before is removed
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: "app", Namespace: "app-ns"}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	instance := &subv1.Subscription{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "sub", Namespace: "app-ns"}, instance)).To(gomega.Succeed())
+	g.Expect(instance.OwnerReferences).To(gomega.BeEmpty())
+}
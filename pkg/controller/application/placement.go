@@ -0,0 +1,183 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"sort"
+
+	"github.com/stolostron/multicloud-operators-application/utils"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	plrv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// placementKind is the kind of the OCM placements, their decisions sit in PlacementDecisions
+	placementKind = "Placement"
+	// placementRuleKind is the kind of the legacy placement rules, their decisions sit in the rule status
+	placementRuleKind = "PlacementRule"
+)
+
+// getPlacementRef returns the kind, namespace and name of the placement referred by the subscription, empty kind if none.
+// A placement reference without kind or group refers to a legacy placement rule.
+func getPlacementRef(sub *subv1.Subscription) (string, string, string) {
+	if sub.Spec.Placement == nil || sub.Spec.Placement.PlacementRef == nil || sub.Spec.Placement.PlacementRef.Name == "" {
+		return "", "", ""
+	}
+
+	ref := sub.Spec.Placement.PlacementRef
+
+	kind := placementRuleKind
+
+	gv, _ := schema.ParseGroupVersion(ref.APIVersion)
+	if ref.Kind == placementKind || gv.Group == clusterv1beta1.GroupName {
+		kind = placementKind
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = sub.Namespace
+	}
+
+	return kind, namespace, ref.Name
+}
+
+// getPlacementRefKey returns "kind/namespace/name" of the placement referred by the subscription, empty if none
+func getPlacementRefKey(sub *subv1.Subscription) string {
+	kind, namespace, name := getPlacementRef(sub)
+	if kind == "" {
+		return ""
+	}
+
+	return kind + "/" + namespace + "/" + name
+}
+
+// getPlacementClusters returns the managed clusters decided for the placement referred by the subscription, sorted.
+// It returns false if the subscription refers to no placement, or the placement is not found on the hub
+// or in the namespaces watched by the controller.
+func (r *ReconcileApplication) getPlacementClusters(sub *subv1.Subscription) ([]string, bool, error) {
+	kind, namespace, name := getPlacementRef(sub)
+	if kind == "" || !isWatchedNamespace(namespace) {
+		return nil, false, nil
+	}
+
+	clusterSet := make(map[string]bool)
+
+	if kind == placementKind {
+		decisionList := &clusterv1beta1.PlacementDecisionList{}
+
+		err := r.List(context.TODO(), decisionList, client.InNamespace(namespace),
+			client.MatchingLabels{clusterv1beta1.PlacementLabel: name})
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				return nil, false, nil
+			}
+
+			return nil, false, err
+		}
+
+		for _, decision := range decisionList.Items {
+			for _, clusterDecision := range decision.Status.Decisions {
+				clusterSet[clusterDecision.ClusterName] = true
+			}
+		}
+	} else {
+		rule := &plrv1.PlacementRule{}
+
+		err := r.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, rule)
+		if err != nil {
+			if meta.IsNoMatchError(err) || errors.IsNotFound(err) {
+				return nil, false, nil
+			}
+
+			return nil, false, err
+		}
+
+		for _, decision := range rule.Status.Decisions {
+			clusterSet[decision.ClusterName] = true
+		}
+	}
+
+	clusters := make([]string, 0, len(clusterSet))
+
+	for cluster := range clusterSet {
+		if cluster != "" {
+			clusters = append(clusters, cluster)
+		}
+	}
+
+	sort.Strings(clusters)
+
+	return clusters, true, nil
+}
+
+// isKindInstalled tells if the kind is served by the hub
+func isKindInstalled(restMapper meta.RESTMapper, gvk schema.GroupVersionKind) bool {
+	_, err := restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		klog.Info("Skip watching the kind not served by the hub: ", gvk.String(), " err: ", err)
+		return false
+	}
+
+	return true
+}
+
+type placementMapper struct {
+	client.Client
+}
+
+// mapPlacement enqueues the applications selecting the subscriptions referring to the placement
+func (mapper *placementMapper) mapPlacement(refKey string) []reconcile.Request {
+	finder := newMemberAppFinder(mapper.Client)
+
+	subscriptionList := &subv1.SubscriptionList{}
+
+	err := mapper.List(context.TODO(), subscriptionList, client.MatchingFields{subscriptionPlacementRefIndex: refKey})
+	if err != nil {
+		klog.Error("Failed to list subscription objects referring to placement ", refKey, " error: ", err)
+		return nil
+	}
+
+	for _, subscription := range subscriptionList.Items {
+		finder.addObject(utils.SubscriptionGroupKind, subscription.Namespace, subscription.Labels)
+	}
+
+	return finder.getRequests()
+}
+
+func (mapper *placementMapper) MapDecision(ctx context.Context, obj *clusterv1beta1.PlacementDecision) []reconcile.Request {
+	klog.V(1).Info("In placement decision Mapper:", obj.GetName(), "/", obj.GetNamespace())
+
+	placement := obj.GetLabels()[clusterv1beta1.PlacementLabel]
+	if placement == "" {
+		return nil
+	}
+
+	return mapper.mapPlacement(placementKind + "/" + obj.GetNamespace() + "/" + placement)
+}
+
+func (mapper *placementMapper) MapRule(ctx context.Context, obj *plrv1.PlacementRule) []reconcile.Request {
+	klog.V(1).Info("In placement rule Mapper:", obj.GetName(), "/", obj.GetNamespace())
+
+	return mapper.mapPlacement(placementRuleKind + "/" + obj.GetNamespace() + "/" + obj.GetName())
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	clusterv1beta1 "open-cluster-management.io/api/cluster/v1beta1"
+	plrv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/placementrule/v1"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newPlacementTestSub(name string, ref *corev1.ObjectReference) *subv1.Subscription {
+	return &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app-ns"},
+		Spec: subv1.SubscriptionSpec{
+			Channel:   "chn-ns/chn",
+			Placement: &plrv1.Placement{PlacementRef: ref},
+		},
+	}
+}
+
+func TestGetPlacementClusters(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	decision1 := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "placement-decision-1", Namespace: "app-ns",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "placement"},
+		},
+		Status: clusterv1beta1.PlacementDecisionStatus{
+			Decisions: []clusterv1beta1.ClusterDecision{{ClusterName: "cluster2"}, {ClusterName: "cluster1"}},
+		},
+	}
+
+	decision2 := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "placement-decision-2", Namespace: "app-ns",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "placement"},
+		},
+		Status: clusterv1beta1.PlacementDecisionStatus{
+			Decisions: []clusterv1beta1.ClusterDecision{{ClusterName: "cluster3"}},
+		},
+	}
+
+	rule := &plrv1.PlacementRule{
+		ObjectMeta: metav1.ObjectMeta{Name: "rule", Namespace: "app-ns"},
+		Status: plrv1.PlacementRuleStatus{
+			Decisions: []plrv1.PlacementDecision{{ClusterName: "local-cluster", ClusterNamespace: "local-cluster"}},
+		},
+	}
+
+	r := &ReconcileApplication{
+		Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(decision1, decision2, rule).Build(),
+	}
+
+	placementSub := newPlacementTestSub("placement-sub", &corev1.ObjectReference{Kind: "Placement", Name: "placement"})
+	g.Expect(getPlacementRefKey(placementSub)).To(gomega.Equal("Placement/app-ns/placement"))
+
+	clusters, found, err := r.getPlacementClusters(placementSub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeTrue())
+	g.Expect(clusters).To(gomega.Equal([]string{"cluster1", "cluster2", "cluster3"}))
+
+	// a placement reference without kind refers to a legacy placement rule
+	ruleSub := newPlacementTestSub("rule-sub", &corev1.ObjectReference{Name: "rule"})
+	g.Expect(getPlacementRefKey(ruleSub)).To(gomega.Equal("PlacementRule/app-ns/rule"))
+
+	clusters, found, err = r.getPlacementClusters(ruleSub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeTrue())
+	g.Expect(clusters).To(gomega.Equal([]string{"local-cluster"}))
+
+	missingRuleSub := newPlacementTestSub("missing-sub", &corev1.ObjectReference{Kind: "PlacementRule", Name: "missing"})
+
+	_, found, err = r.getPlacementClusters(missingRuleSub)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeFalse())
+
+	_, found, err = r.getPlacementClusters(newPlacementTestSub("no-placement-sub", nil))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(found).To(gomega.BeFalse())
+}
+
+func TestPlacementMapper(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	placementSub := newPlacementTestSub("placement-sub", &corev1.ObjectReference{Kind: "Placement", Name: "placement"})
+	placementSub.Labels = map[string]string{"app": "nginx"}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).
+		WithObjects(placementSub,
+			newEnqueueTestApp("nginx", "app-ns", map[string]string{"app": "nginx"}),
+			newEnqueueTestApp("redis", "app-ns", map[string]string{"app": "redis"})).
+		WithIndex(&subv1.Subscription{}, subscriptionPlacementRefIndex, subscriptionPlacementRefIndexer).
+		WithIndex(&appv1beta1.Application{}, applicationCrossNamespaceIndex, applicationCrossNamespaceIndexer).
+		Build()
+
+	pmapper := &placementMapper{Client: clt}
+
+	decision := &clusterv1beta1.PlacementDecision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "placement-decision-1", Namespace: "app-ns",
+			Labels: map[string]string{clusterv1beta1.PlacementLabel: "placement"},
+		},
+	}
+	g.Expect(getRequestKeys(pmapper.MapDecision(context.TODO(), decision))).To(gomega.ConsistOf("app-ns/nginx"))
+
+	rule := &plrv1.PlacementRule{ObjectMeta: metav1.ObjectMeta{Name: "placement", Namespace: "app-ns"}}
+	g.Expect(pmapper.MapRule(context.TODO(), rule)).To(gomega.BeEmpty())
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newControllerOptions returns the controller options of the reconciler, with the workers and the rate limits
+// of Options. A failing request is retried with the per-item exponential backoff. The queue hands out all requests,
+// added by the watches or retried, at the rate of the bucket.
+func newControllerOptions(r reconcile.Reconciler) controller.Options {
+	limiter := rate.NewLimiter(rate.Limit(Options.QPS), Options.Burst)
+
+	return controller.Options{
+		Reconciler:              r,
+		MaxConcurrentReconciles: Options.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](
+			Options.BackoffBase, Options.BackoffMax),
+		NewQueue: func(controllerName string,
+			rateLimiter workqueue.TypedRateLimiter[reconcile.Request]) workqueue.TypedRateLimitingInterface[reconcile.Request] {
+			queue := workqueue.NewTypedRateLimitingQueueWithConfig(rateLimiter,
+				workqueue.TypedRateLimitingQueueConfig[reconcile.Request]{Name: controllerName})
+
+			return &limitedQueue{queue, limiter}
+		},
+	}
+}
+
+// limitedQueue waits for a token of the limiter before handing out a request. The requests are limited when taken
+// rather than added, so a request added again while waiting doesn't take another token.
+type limitedQueue struct {
+	workqueue.TypedRateLimitingInterface[reconcile.Request]
+	limiter *rate.Limiter
+}
+
+func (q *limitedQueue) Get() (reconcile.Request, bool) {
+	item, shutdown := q.TypedRateLimitingInterface.Get()
+
+	if shutdown {
+		return item, shutdown
+	}
+
+	if r := q.limiter.Reserve(); r.OK() {
+		time.Sleep(r.Delay())
+	}
+
+	return item, shutdown
+}
+
+// debounce delays the requests of the handler by Options.EnqueueDebounce. A request already waiting is not added again,
+// so the events of the same application within the delay are coalesced into one reconcile.
+func debounce[T client.Object](h handler.TypedEventHandler[T, reconcile.Request]) handler.TypedEventHandler[T, reconcile.Request] {
+	if Options.EnqueueDebounce <= 0 {
+		return h
+	}
+
+	return &debouncedHandler[T]{handler: h, delay: Options.EnqueueDebounce}
+}
+
+// debouncedHandler hands the wrapped handler a queue adding the requests after the delay
+type debouncedHandler[T client.Object] struct {
+	handler handler.TypedEventHandler[T, reconcile.Request]
+	delay   time.Duration
+}
+
+func (h *debouncedHandler[T]) Create(ctx context.Context, e event.TypedCreateEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.handler.Create(ctx, e, &delayingQueue{q, h.delay})
+}
+
+func (h *debouncedHandler[T]) Update(ctx context.Context, e event.TypedUpdateEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.handler.Update(ctx, e, &delayingQueue{q, h.delay})
+}
+
+func (h *debouncedHandler[T]) Delete(ctx context.Context, e event.TypedDeleteEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.handler.Delete(ctx, e, &delayingQueue{q, h.delay})
+}
+
+func (h *debouncedHandler[T]) Generic(ctx context.Context, e event.TypedGenericEvent[T],
+	q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.handler.Generic(ctx, e, &delayingQueue{q, h.delay})
+}
+
+// delayingQueue turns the adds into adds after the delay. The delaying queue keeps the earliest time of a waiting request.
+type delayingQueue struct {
+	workqueue.TypedRateLimitingInterface[reconcile.Request]
+	delay time.Duration
+}
+
+func (q *delayingQueue) Add(item reconcile.Request) {
+	q.AddAfter(item, q.delay)
+}
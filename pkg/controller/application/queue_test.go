@@ -0,0 +1,114 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDebounceCoalescesEnqueues(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(delay time.Duration) { Options.EnqueueDebounce = delay }(Options.EnqueueDebounce)
+
+	appKey := types.NamespacedName{Name: "app", Namespace: "app-ns"}
+	mapped := handler.TypedEnqueueRequestsFromMapFunc(func(context.Context, *dplv1.Deployable) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: appKey}}
+	})
+
+	// not delayed by default
+	g.Expect(debounce(mapped)).To(gomega.BeIdenticalTo(mapped))
+
+	Options.EnqueueDebounce = 100 * time.Millisecond
+
+	queue := workqueue.NewTypedRateLimitingQueue(newControllerOptions(nil).RateLimiter)
+	defer queue.ShutDown()
+
+	h := debounce(mapped)
+
+	// a storm of deployable updates
+	for i := 0; i < 10; i++ {
+		dpl := &dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "dpl", Namespace: "app-ns"}}
+		h.Update(context.TODO(), event.TypedUpdateEvent[*dplv1.Deployable]{ObjectOld: dpl, ObjectNew: dpl}, queue)
+	}
+
+	g.Expect(queue.Len()).To(gomega.Equal(0))
+	g.Eventually(queue.Len).Should(gomega.Equal(1))
+	g.Consistently(queue.Len, 200*time.Millisecond).Should(gomega.Equal(1))
+
+	item, _ := queue.Get()
+	g.Expect(item).To(gomega.Equal(reconcile.Request{NamespacedName: appKey}))
+}
+
+func TestRateLimiterLimitsAllReconciles(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	defer func(qps float64, burst int) { Options.QPS, Options.Burst = qps, burst }(Options.QPS, Options.Burst)
+
+	Options.QPS = 10
+	Options.Burst = 1
+
+	opts := newControllerOptions(nil)
+
+	queue := opts.NewQueue("test", opts.RateLimiter)
+	defer queue.ShutDown()
+
+	h := &handler.TypedEnqueueRequestForObject[*dplv1.Deployable]{}
+
+	// a burst of watch events is added at once
+	for i := 0; i < 10; i++ {
+		dpl := &dplv1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "dpl-" + strconv.Itoa(i), Namespace: "app-ns"}}
+		h.Generic(context.TODO(), event.TypedGenericEvent[*dplv1.Deployable]{Object: dpl}, queue)
+	}
+
+	g.Expect(queue.Len()).To(gomega.Equal(10))
+
+	// but handed out at the rate of the bucket, one request every 100ms after the burst
+	start := time.Now()
+
+	for i := 0; i < 4; i++ {
+		item, shutdown := queue.Get()
+		g.Expect(shutdown).To(gomega.BeFalse())
+		queue.Done(item)
+	}
+
+	g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 250*time.Millisecond))
+
+	// the retries take the tokens of the same bucket
+	retry := reconcile.Request{NamespacedName: types.NamespacedName{Name: "dpl-0", Namespace: "app-ns"}}
+	queue.AddRateLimited(retry)
+
+	start = time.Now()
+
+	for i := 0; i < 7; i++ {
+		item, _ := queue.Get()
+		queue.Done(item)
+	}
+
+	g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 600*time.Millisecond))
+	g.Expect(queue.Len()).To(gomega.Equal(0))
+}
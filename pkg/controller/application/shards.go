@@ -0,0 +1,454 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// shardLeasePrefix names the lease of each shard, "<prefix><shard>"
+	shardLeasePrefix = "multicloud-operators-application-shard-"
+	// replicaLeasePrefix names the lease each replica renews to announce itself, "<prefix><identity>"
+	replicaLeasePrefix = "multicloud-operators-application-replica-"
+	// labelShardReplica sits in the replica leases
+	labelShardReplica = "apps.open-cluster-management.io/shard-replica"
+	// podNameEnvVar is the identity of the replica
+	podNameEnvVar = "POD_NAME"
+	// shardReleaseTimeout bounds the release of the leases when the replica stops
+	shardReleaseTimeout = 5 * time.Second
+	// shardRenewDeadlineRatio is the renew deadline in thirds of the lease duration, the leases are renewed every third.
+	// The last third covers the clock skew between the replicas.
+	shardRenewDeadlineRatio = 2
+)
+
+var (
+	// shardOwnedGauge tells which shards the replica owns
+	shardOwnedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_shard_owned",
+		Help: "Whether the replica owns the application shard, 1 if owned, 0 otherwise",
+	}, []string{"shard"})
+	// shardReplicasGauge counts the live replicas sharing the shards
+	shardReplicasGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "application_shard_replicas",
+		Help: "Number of live replicas the application shards are spread over, as seen by the replica",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(shardOwnedGauge, shardReplicasGauge)
+}
+
+// getNamespaceShard returns the shard of the namespace, the applications of a namespace always share the shard
+func getNamespaceShard(namespace string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+
+	return int(h.Sum32() % uint32(shards))
+}
+
+// shardManager spreads the applications over the live replicas by the hash of their namespace.
+// Each replica renews its replica lease, the shards are dealt round-robin over the sorted live replicas, and a replica
+// owns a shard while it holds the lease of the shard. A shard is released by its holder before another replica takes it.
+// As in leader election, the replica stops owning a shard it failed to renew at the renew deadline, counted from the
+// renew time written in the lease, before the other replicas see the lease expire.
+type shardManager struct {
+	client.Client
+	// apiReader reads the leases, the leases of the cluster are not cached
+	apiReader client.Reader
+
+	shards        int
+	identity      string
+	namespace     string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	now           func() time.Time
+
+	lock sync.RWMutex
+	// renewed is the renew time written in the lease of each owned shard
+	renewed map[int]time.Time
+
+	// events enqueues the applications of the shards acquired by the replica
+	events chan event.TypedGenericEvent[*appv1beta1.Application]
+	// subEvents enqueues the subscriptions of the shards acquired by the replica into discovery, nil without discovery
+	subEvents chan event.TypedGenericEvent[*subv1.Subscription]
+}
+
+func newShardManager(clt client.Client, apiReader client.Reader, namespace string, shards int,
+	leaseDuration time.Duration) *shardManager {
+	identity := os.Getenv(podNameEnvVar)
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+
+	return &shardManager{
+		Client:        clt,
+		apiReader:     apiReader,
+		shards:        shards,
+		identity:      identity,
+		namespace:     namespace,
+		leaseDuration: leaseDuration,
+		renewDeadline: leaseDuration * shardRenewDeadlineRatio / 3,
+		now:           time.Now,
+		renewed:       make(map[int]time.Time),
+		events:        make(chan event.TypedGenericEvent[*appv1beta1.Application], 1024),
+	}
+}
+
+// NeedLeaderElection tells the manager to run the shard manager on every replica
+func (s *shardManager) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the leases until the context is done, then releases them so the other replicas take over at once
+func (s *shardManager) Start(ctx context.Context) error {
+	klog.Info("Sharding applications over ", s.shards, " shards as replica: ", s.identity)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := s.sync(ctx); err != nil {
+			klog.Error("Failed to sync the application shards, error: ", err)
+		}
+	}, s.leaseDuration/3)
+
+	releaseCtx, cancel := context.WithTimeout(context.Background(), shardReleaseTimeout)
+	defer cancel()
+
+	s.release(releaseCtx)
+
+	return nil
+}
+
+// ownsShard tells if the replica holds the lease of the shard, a nil manager owns all shards
+func (s *shardManager) ownsShard(shard int) bool {
+	if s == nil {
+		return true
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	renewed, ok := s.renewed[shard]
+
+	return ok && s.now().Sub(renewed) < s.renewDeadline
+}
+
+// ownsNamespace tells if the replica owns the shard of the namespace, a nil manager owns all namespaces
+func (s *shardManager) ownsNamespace(namespace string) bool {
+	if s == nil {
+		return true
+	}
+
+	return s.ownsShard(getNamespaceShard(namespace, s.shards))
+}
+
+// sync renews the replica lease, then acquires, renews or releases the shard leases
+func (s *shardManager) sync(ctx context.Context) error {
+	if err := s.renewReplicaLease(ctx); err != nil {
+		return err
+	}
+
+	replicas, err := s.getLiveReplicas(ctx)
+	if err != nil {
+		return err
+	}
+
+	shardReplicasGauge.Set(float64(len(replicas)))
+
+	index := sort.SearchStrings(replicas, s.identity)
+
+	var acquired []int
+
+	for shard := 0; shard < s.shards; shard++ {
+		wanted := index < len(replicas) && replicas[index] == s.identity && shard%len(replicas) == index
+
+		owned, isNew, err := s.syncShard(ctx, shard, wanted)
+		if err != nil {
+			klog.Error("Failed to sync the lease of application shard: ", shard, " error: ", err)
+			continue
+		}
+
+		if owned && isNew {
+			acquired = append(acquired, shard)
+		}
+	}
+
+	s.updateMetrics()
+
+	if len(acquired) > 0 {
+		klog.Info("Acquired application shards: ", acquired)
+
+		return s.enqueueShards(ctx, acquired)
+	}
+
+	return nil
+}
+
+// syncShard acquires or renews the lease of the wanted shard, and releases the unwanted shard.
+// It returns if the replica owns the shard, and if the shard is newly owned.
+func (s *shardManager) syncShard(ctx context.Context, shard int, wanted bool) (bool, bool, error) {
+	lease := &coordinationv1.Lease{}
+	name := shardLeasePrefix + strconv.Itoa(shard)
+
+	err := s.apiReader.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: name}, lease)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, false, err
+		}
+
+		if !wanted {
+			return false, false, nil
+		}
+
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: name}}
+		renewed := s.setHolder(lease, true)
+
+		if err := s.Create(ctx, lease, client.FieldOwner(fieldManager)); err != nil {
+			return false, false, err
+		}
+
+		return true, s.setOwned(shard, &renewed), nil
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+
+	var renewed time.Time
+
+	switch {
+	case holder == s.identity && !wanted:
+		// release, the wanting replica takes the shard on its next sync
+		lease.Spec.HolderIdentity = nil
+		s.setOwned(shard, nil)
+
+		return false, false, s.Update(ctx, lease, client.FieldOwner(fieldManager))
+	case holder == s.identity:
+		renewed = s.setHolder(lease, false)
+	case wanted && (holder == "" || s.isExpired(lease)):
+		klog.Info("Taking application shard: ", shard, " from replica: ", holder)
+		renewed = s.setHolder(lease, true)
+	default:
+		return false, s.setOwned(shard, nil), nil
+	}
+
+	if err := s.Update(ctx, lease, client.FieldOwner(fieldManager)); err != nil {
+		return false, false, err
+	}
+
+	return true, s.setOwned(shard, &renewed), nil
+}
+
+// renewReplicaLease creates or renews the lease announcing the replica
+func (s *shardManager) renewReplicaLease(ctx context.Context) error {
+	lease := &coordinationv1.Lease{}
+	name := replicaLeasePrefix + s.identity
+
+	err := s.apiReader.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: name}, lease)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.namespace,
+			Name:      name,
+			Labels:    map[string]string{labelShardReplica: "true"},
+		}}
+		s.setHolder(lease, true)
+
+		return s.Create(ctx, lease, client.FieldOwner(fieldManager))
+	}
+
+	s.setHolder(lease, false)
+
+	return s.Update(ctx, lease, client.FieldOwner(fieldManager))
+}
+
+// getLiveReplicas returns the sorted identities of the replicas whose lease is not expired
+func (s *shardManager) getLiveReplicas(ctx context.Context) ([]string, error) {
+	leaseList := &coordinationv1.LeaseList{}
+
+	err := s.apiReader.List(ctx, leaseList, client.InNamespace(s.namespace), client.MatchingLabels{labelShardReplica: "true"})
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas []string
+
+	for i := range leaseList.Items {
+		lease := &leaseList.Items[i]
+
+		if lease.Spec.HolderIdentity != nil && !s.isExpired(lease) {
+			replicas = append(replicas, *lease.Spec.HolderIdentity)
+		}
+	}
+
+	sort.Strings(replicas)
+
+	return replicas, nil
+}
+
+// setHolder makes the replica the holder of the lease and renews it, it returns the renew time
+func (s *shardManager) setHolder(lease *coordinationv1.Lease, acquire bool) time.Time {
+	now := metav1.NewMicroTime(s.now())
+	seconds := int32(s.leaseDuration.Seconds())
+
+	if acquire {
+		lease.Spec.AcquireTime = &now
+
+		if lease.Spec.LeaseTransitions == nil {
+			lease.Spec.LeaseTransitions = new(int32)
+		} else {
+			*lease.Spec.LeaseTransitions++
+		}
+	}
+
+	lease.Spec.HolderIdentity = &s.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+
+	return now.Time
+}
+
+// isExpired tells if the holder of the lease stopped renewing it
+func (s *shardManager) isExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+
+	return !s.now().Before(expiry)
+}
+
+// setOwned records the renew time written in the lease of the owned shard, nil if the shard is not owned.
+// It tells if the shard is newly owned.
+func (s *shardManager) setOwned(shard int, renewed *time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	last, ok := s.renewed[shard]
+	wasOwned := ok && s.now().Sub(last) < s.renewDeadline
+
+	if renewed != nil {
+		s.renewed[shard] = *renewed
+	} else {
+		delete(s.renewed, shard)
+	}
+
+	return renewed != nil && !wasOwned
+}
+
+// updateMetrics reports the shards owned by the replica
+func (s *shardManager) updateMetrics() {
+	for shard := 0; shard < s.shards; shard++ {
+		owned := 0.0
+		if s.ownsShard(shard) {
+			owned = 1
+		}
+
+		shardOwnedGauge.WithLabelValues(strconv.Itoa(shard)).Set(owned)
+	}
+}
+
+// enqueueShards enqueues the applications of the shards, and their subscriptions with discovery. They may have changed
+// while another replica owned them.
+func (s *shardManager) enqueueShards(ctx context.Context, shards []int) error {
+	wanted := make(map[int]bool, len(shards))
+	for _, shard := range shards {
+		wanted[shard] = true
+	}
+
+	applicationList := &appv1beta1.ApplicationList{}
+	if err := s.List(ctx, applicationList); err != nil {
+		return err
+	}
+
+	for i := range applicationList.Items {
+		app := &applicationList.Items[i]
+
+		if wanted[getNamespaceShard(app.Namespace, s.shards)] {
+			select {
+			case s.events <- event.TypedGenericEvent[*appv1beta1.Application]{Object: app}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	if s.subEvents == nil {
+		return nil
+	}
+
+	subscriptionList := &subv1.SubscriptionList{}
+	if err := s.List(ctx, subscriptionList); err != nil {
+		return err
+	}
+
+	for i := range subscriptionList.Items {
+		sub := &subscriptionList.Items[i]
+
+		if wanted[getNamespaceShard(sub.Namespace, s.shards)] {
+			select {
+			case s.subEvents <- event.TypedGenericEvent[*subv1.Subscription]{Object: sub}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// release gives up the shard leases and the replica lease, so the other replicas rebalance at once
+func (s *shardManager) release(ctx context.Context) {
+	for shard := 0; shard < s.shards; shard++ {
+		if !s.ownsShard(shard) {
+			continue
+		}
+
+		if _, _, err := s.syncShard(ctx, shard, false); err != nil {
+			klog.Error("Failed to release application shard: ", shard, " error: ", err)
+		}
+	}
+
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: replicaLeasePrefix + s.identity}}
+
+	if err := s.Delete(ctx, lease); err != nil && !errors.IsNotFound(err) {
+		klog.Error("Failed to delete the replica lease, error: ", err)
+	}
+
+	s.updateMetrics()
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestShardManager(clt client.Client, identity string, now *time.Time) *shardManager {
+	s := newShardManager(clt, clt, "controller-ns", 4, 30*time.Second)
+	s.identity = identity
+	s.now = func() time.Time { return *now }
+
+	return s
+}
+
+func getOwnedShards(s *shardManager) []int {
+	owned := []int{}
+
+	for shard := 0; shard < s.shards; shard++ {
+		if s.ownsShard(shard) {
+			owned = append(owned, shard)
+		}
+	}
+
+	return owned
+}
+
+func TestShardManagerRebalances(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+
+	replicaA := newTestShardManager(clt, "replica-a", &now)
+	replicaB := newTestShardManager(clt, "replica-b", &now)
+
+	// alone, the first replica takes all shards
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.Equal([]int{0, 1, 2, 3}))
+	g.Expect(testutil.ToFloat64(shardOwnedGauge.WithLabelValues("3"))).To(gomega.Equal(1.0))
+
+	// the joining replica waits for its shards to be released
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.BeEmpty())
+
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.Equal([]int{0, 2}))
+
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.Equal([]int{1, 3}))
+	g.Expect(testutil.ToFloat64(shardReplicasGauge)).To(gomega.Equal(2.0))
+
+	// each namespace is owned by exactly one replica
+	for _, namespace := range []string{"ns1", "ns2", "ns3", "ns4", "ns5"} {
+		g.Expect(replicaA.ownsNamespace(namespace)).NotTo(gomega.Equal(replicaB.ownsNamespace(namespace)))
+	}
+
+	// the second replica stops renewing, its shards go back to the first replica once the leases expire
+	now = now.Add(20 * time.Second)
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.Equal([]int{0, 2}))
+
+	now = now.Add(20 * time.Second)
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.Equal([]int{0, 1, 2, 3}))
+	g.Expect(getOwnedShards(replicaB)).To(gomega.BeEmpty())
+}
+
+func TestShardManagerRenewDeadline(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+
+	replicaA := newTestShardManager(clt, "replica-a", &now)
+	replicaB := newTestShardManager(clt, "replica-b", &now)
+
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.Equal([]int{0, 1, 2, 3}))
+
+	// the first replica fails to renew, it stops owning its shards at the renew deadline, before its leases expire
+	now = now.Add(21 * time.Second)
+	g.Expect(getOwnedShards(replicaA)).To(gomega.BeEmpty())
+
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.BeEmpty())
+
+	// the second replica takes the shards once the leases expire
+	now = now.Add(10 * time.Second)
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.Equal([]int{0, 1, 2, 3}))
+}
+
+func TestShardManagerReleasesAndEnqueues(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+
+	var apps []client.Object
+	for _, namespace := range []string{"ns1", "ns2", "ns3", "ns4", "ns5"} {
+		apps = append(apps, newEnqueueTestApp("app", namespace, nil))
+	}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(apps...).Build()
+
+	replicaA := newTestShardManager(clt, "replica-a", &now)
+	replicaB := newTestShardManager(clt, "replica-b", &now)
+
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(replicaA.events).To(gomega.HaveLen(len(apps)))
+
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+
+	// the first replica stops, the second one takes its shards at once and enqueues their applications
+	replicaA.release(context.TODO())
+	g.Expect(getOwnedShards(replicaA)).To(gomega.BeEmpty())
+
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.Equal([]int{0, 1, 2, 3}))
+	g.Expect(replicaB.events).To(gomega.HaveLen(len(apps)))
+
+	var namespaces []string
+
+	for len(replicaB.events) > 0 {
+		evt := <-replicaB.events
+		namespaces = append(namespaces, evt.Object.Namespace)
+	}
+
+	g.Expect(namespaces).To(gomega.ConsistOf("ns1", "ns2", "ns3", "ns4", "ns5"))
+
+}
+
+func TestShardManagerEnqueuesSubscriptions(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	now := time.Now()
+
+	var subs []client.Object
+	for _, namespace := range []string{"ns1", "ns2", "ns3"} {
+		subs = append(subs, &subv1.Subscription{ObjectMeta: metav1.ObjectMeta{Name: "sub", Namespace: namespace}})
+	}
+
+	clt := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(subs...).Build()
+
+	// without discovery, no subscription is enqueued
+	replicaA := newTestShardManager(clt, "replica-a", &now)
+	g.Expect(replicaA.sync(context.TODO())).To(gomega.Succeed())
+	replicaA.release(context.TODO())
+
+	// with discovery, the subscriptions of the acquired shards are enqueued too
+	replicaB := newTestShardManager(clt, "replica-b", &now)
+	replicaB.subEvents = make(chan event.TypedGenericEvent[*subv1.Subscription], 1024)
+
+	g.Expect(replicaB.sync(context.TODO())).To(gomega.Succeed())
+	g.Expect(getOwnedShards(replicaB)).To(gomega.Equal([]int{0, 1, 2, 3}))
+	g.Expect(replicaB.subEvents).To(gomega.HaveLen(len(subs)))
+
+	var namespaces []string
+
+	for len(replicaB.subEvents) > 0 {
+		evt := <-replicaB.subEvents
+		namespaces = append(namespaces, evt.Object.Namespace)
+	}
+
+	g.Expect(namespaces).To(gomega.ConsistOf("ns1", "ns2", "ns3"))
+}
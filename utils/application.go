@@ -4,8 +4,13 @@ import (
 	"encoding/json"
 
 	"reflect"
+	"strings"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -15,6 +20,96 @@ import (
 	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
 )
 
+const (
+	// AnnotationAppSubscriptions lists the subscriptions selected by the application, comma-joined
+	AnnotationAppSubscriptions = "apps.open-cluster-management.io/subscriptions"
+	// AnnotationAppDeployables lists the deployables selected by the application, comma-joined
+	AnnotationAppDeployables = "apps.open-cluster-management.io/deployables"
+	// AnnotationMembershipHash is the sha256 of the application component set, it changes only when the membership changes
+	AnnotationMembershipHash = "apps.open-cluster-management.io/membership-hash"
+	// AnnotationMembersConfigMap names the companion ConfigMap holding the members of the application,
+	// when they don't fit in the subscriptions/deployables annotations
+	AnnotationMembersConfigMap = "apps.open-cluster-management.io/members-configmap"
+	// AnnotationMembersSummary counts the members of the application written to the companion ConfigMap
+	AnnotationMembersSummary = "apps.open-cluster-management.io/members-summary"
+	// AnnotationAppOwners sits in a subscription or deployable, lists the "namespace/name" of the applications selecting it
+	AnnotationAppOwners = "apps.open-cluster-management.io/applications"
+	// AnnotationMemberNamespaces lists the other namespaces the application selects members from, comma-joined
+	AnnotationMemberNamespaces = "apps.open-cluster-management.io/member-namespaces"
+	// AnnotationMemberNamespaceSelector is the label selector of the other namespaces the application selects members from
+	AnnotationMemberNamespaceSelector = "apps.open-cluster-management.io/member-namespace-selector"
+	// AnnotationAllowedAppNamespaces sits in a namespace, lists the application namespaces allowed to select members from it.
+	// "*" allows applications in any namespace.
+	AnnotationAllowedAppNamespaces = "apps.open-cluster-management.io/allowed-application-namespaces"
+)
+
+const (
+	// AppConditionNamespacesAuthorized tells if all requested member namespaces allow the application
+	AppConditionNamespacesAuthorized appv1beta1.ConditionType = "NamespacesAuthorized"
+
+	// ReasonNamespacesAllowed all requested member namespaces allow the application
+	ReasonNamespacesAllowed = "NamespacesAllowed"
+	// ReasonNamespacesNotAllowed some requested member namespaces don't allow the application
+	ReasonNamespacesNotAllowed = "NamespacesNotAllowed"
+)
+
+const (
+	// AppConditionOverlappingMembership tells the application components are selected by other applications too
+	AppConditionOverlappingMembership appv1beta1.ConditionType = "OverlappingMembership"
+
+	// ReasonComponentsShared some components of the application are selected by other applications
+	ReasonComponentsShared = "ComponentsShared"
+)
+
+const (
+	// AppFinalizer holds the application deletion until its components are cleaned up
+	AppFinalizer = "apps.open-cluster-management.io/application-cleanup"
+	// AnnotationDeletionPolicy tells what happens to the application components when the application is deleted,
+	// DeletionPolicyOrphan or DeletionPolicyCascade
+	AnnotationDeletionPolicy = "apps.open-cluster-management.io/deletion-policy"
+	// DeletionPolicyOrphan keeps the components, only the references to the application are removed from them. The default.
+	DeletionPolicyOrphan = "orphan"
+	// DeletionPolicyCascade deletes the subscriptions, then the deployables selected by the application. The components
+	// selected by other applications, or in other namespaces, are kept. It is refused for the applications selecting all.
+	DeletionPolicyCascade = "cascade"
+
+	// AppConditionTerminating reports the cleanup progress of the deleted application
+	AppConditionTerminating appv1beta1.ConditionType = "Terminating"
+
+	// ReasonRemovingReferences the references to the application are being removed from the components
+	ReasonRemovingReferences = "RemovingReferences"
+	// ReasonDeletingSubscriptions the subscriptions of the application are being deleted
+	ReasonDeletingSubscriptions = "DeletingSubscriptions"
+	// ReasonDeletingDeployables the deployables of the application are being deleted
+	ReasonDeletingDeployables = "DeletingDeployables"
+	// ReasonCascadeRefused the application selects all components of its namespaces, they are orphaned instead of deleted
+	ReasonCascadeRefused = "CascadeRefused"
+)
+
+const (
+	// LabelGeneratedApp sits in the applications generated by the discovery mode for the subscriptions no application selects.
+	// A generated application is deleted when its last subscription is gone.
+	LabelGeneratedApp = "apps.open-cluster-management.io/generated-application"
+	// AnnotationGeneratedFrom names the subscription the generated application was created for
+	AnnotationGeneratedFrom = "apps.open-cluster-management.io/generated-from"
+)
+
+// appOwnedAnnotations are the application annotations written by the controller
+var appOwnedAnnotations = []string{
+	AnnotationAppSubscriptions,
+	AnnotationAppDeployables,
+	AnnotationMembershipHash,
+	AnnotationMembersConfigMap,
+	AnnotationMembersSummary,
+}
+
+var (
+	// SubscriptionGroupKind is the component kind of subscriptions
+	SubscriptionGroupKind = metav1.GroupKind{Group: subv1.SchemeGroupVersion.Group, Kind: "Subscription"}
+	// DeployableGroupKind is the component kind of deployables
+	DeployableGroupKind = metav1.GroupKind{Group: dplv1.SchemeGroupVersion.Group, Kind: "Deployable"}
+)
+
 // DeployablePredicateFunc defines predicate function for deployable watch in deployable controller
 var DeployablePredicateFunc = predicate.TypedFuncs[*dplv1.Deployable]{
 	UpdateFunc: func(e event.TypedUpdateEvent[*dplv1.Deployable]) bool {
@@ -97,25 +192,115 @@ var SubscriptionPredicateFunc = predicate.TypedFuncs[*subv1.Subscription]{
 	},
 }
 
+// NamespacePredicateFunc only passes the namespace updates that may change the namespace selection of applications
+var NamespacePredicateFunc = predicate.TypedFuncs[*corev1.Namespace]{
+	UpdateFunc: func(e event.TypedUpdateEvent[*corev1.Namespace]) bool {
+		if !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) {
+			return true
+		}
+
+		return e.ObjectOld.GetAnnotations()[AnnotationAllowedAppNamespaces] != e.ObjectNew.GetAnnotations()[AnnotationAllowedAppNamespaces]
+	},
+}
+
 func UpdateAppInstance(oldApp, newApp *appv1beta1.Application) bool {
-	//check dpl and subscription list annotations
+	//check the annotations written by the controller
 	oldAppAnno := oldApp.GetAnnotations()
-	if oldAppAnno == nil {
-		oldAppAnno = make(map[string]string)
+	newAppAnno := newApp.GetAnnotations()
+
+	for _, key := range appOwnedAnnotations {
+		oldValue, oldOk := oldAppAnno[key]
+		newValue, newOk := newAppAnno[key]
+
+		if oldOk != newOk || oldValue != newValue {
+			return true
+		}
 	}
 
-	newAppAnno := newApp.GetAnnotations()
-	if newAppAnno == nil {
-		newAppAnno = make(map[string]string)
+	return oldApp.Spec.AssemblyPhase != newApp.Spec.AssemblyPhase
+}
+
+// IsAppComponentKind tells if the application selects components of the group kind.
+// An application without componentKinds selects both subscriptions and deployables.
+func IsAppComponentKind(app *appv1beta1.Application, gk metav1.GroupKind) bool {
+	if len(app.Spec.ComponentGroupKinds) == 0 {
+		return gk == SubscriptionGroupKind || gk == DeployableGroupKind
 	}
 
-	if !reflect.DeepEqual(oldAppAnno["apps.open-cluster-management.io/subscriptions"], newAppAnno["apps.open-cluster-management.io/subscriptions"]) {
-		return true
+	for _, appgk := range app.Spec.ComponentGroupKinds {
+		if appgk == gk {
+			return true
+		}
 	}
 
-	if !reflect.DeepEqual(oldAppAnno["apps.open-cluster-management.io/deployables"], newAppAnno["apps.open-cluster-management.io/deployables"]) {
+	return false
+}
+
+// IsAppSelectingObject tells if the application selects the object of the group kind with the labels.
+// The member namespaces of the application are not checked.
+func IsAppSelectingObject(app *appv1beta1.Application, gk metav1.GroupKind, objLabels map[string]string) bool {
+	if !IsAppComponentKind(app, gk) {
+		return false
+	}
+
+	selector, err := ConvertLabels(app.Spec.Selector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(objLabels))
+}
+
+// IsAppSelectingAll tells if the application has no selector or an empty one, selecting all components of its namespaces
+func IsAppSelectingAll(app *appv1beta1.Application) bool {
+	selector := app.Spec.Selector
+
+	return selector == nil || (len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0)
+}
+
+// IsGeneratedApp tells if the application was generated by the discovery mode
+func IsGeneratedApp(app *appv1beta1.Application) bool {
+	return app.GetLabels()[LabelGeneratedApp] == "true"
+}
+
+// GetAppDeletionPolicy returns the deletion policy of the application, orphan unless cascade is set
+func GetAppDeletionPolicy(app *appv1beta1.Application) string {
+	if strings.EqualFold(strings.TrimSpace(app.GetAnnotations()[AnnotationDeletionPolicy]), DeletionPolicyCascade) {
+		return DeletionPolicyCascade
+	}
+
+	return DeletionPolicyOrphan
+}
+
+// IsAppOwnerReference tells if the owner reference points to the application
+func IsAppOwnerReference(ref metav1.OwnerReference, app *appv1beta1.Application) bool {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil || gv.Group != appv1beta1.GroupVersion.Group || ref.Kind != "Application" || ref.Name != app.Name {
+		return false
+	}
+
+	return app.UID == "" || ref.UID == app.UID
+}
+
+// IsCrossNamespaceApp tells if the application opts in to select members from other namespaces
+func IsCrossNamespaceApp(app *appv1beta1.Application) bool {
+	annotations := app.GetAnnotations()
+
+	return annotations[AnnotationMemberNamespaces] != "" || annotations[AnnotationMemberNamespaceSelector] != ""
+}
+
+// IsAppNamespaceAllowed tells if the namespace allows applications in appNamespace to select members from it
+func IsAppNamespaceAllowed(ns *corev1.Namespace, appNamespace string) bool {
+	if ns.Name == appNamespace {
 		return true
 	}
 
+	for _, allowed := range strings.Split(ns.GetAnnotations()[AnnotationAllowedAppNamespaces], ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || allowed == appNamespace {
+			return true
+		}
+	}
+
 	return false
 }
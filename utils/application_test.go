@@ -19,6 +19,7 @@ import (
 
 	"github.com/onsi/gomega"
 	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -214,6 +215,12 @@ func TestUpdateAppInstance(t *testing.T) {
 			}},
 			expected: true,
 		},
+		{
+			name:     "different assembly phases",
+			oldApp:   &appv1beta1.Application{Spec: appv1beta1.ApplicationSpec{AssemblyPhase: appv1beta1.Pending}},
+			newApp:   &appv1beta1.Application{Spec: appv1beta1.ApplicationSpec{AssemblyPhase: appv1beta1.Succeeded}},
+			expected: true,
+		},
 	}
 
 	for _, tC := range tests {
@@ -372,3 +379,84 @@ func TestPredicate(t *testing.T) {
 	ret = instanceDpl.Update(updateEvtDpl)
 	g.Expect(ret).To(gomega.Equal(true))
 }
+
+func TestIsAppComponentKind(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1beta1.Application{}
+
+	// no componentKinds, both subscriptions and deployables are selected
+	g.Expect(IsAppComponentKind(app, SubscriptionGroupKind)).To(gomega.BeTrue())
+	g.Expect(IsAppComponentKind(app, DeployableGroupKind)).To(gomega.BeTrue())
+	g.Expect(IsAppComponentKind(app, metav1.GroupKind{Kind: "ConfigMap"})).To(gomega.BeFalse())
+
+	app.Spec.ComponentGroupKinds = []metav1.GroupKind{
+		{Group: "apps.open-cluster-management.io", Kind: "Subscription"},
+	}
+
+	g.Expect(IsAppComponentKind(app, SubscriptionGroupKind)).To(gomega.BeTrue())
+	g.Expect(IsAppComponentKind(app, DeployableGroupKind)).To(gomega.BeFalse())
+}
+
+func TestIsAppSelectingObject(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1beta1.Application{}
+
+	// no selector, all objects of the component kinds are selected
+	g.Expect(IsAppSelectingObject(app, SubscriptionGroupKind, nil)).To(gomega.BeTrue())
+
+	app.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}}
+	g.Expect(IsAppSelectingObject(app, SubscriptionGroupKind, map[string]string{"app": "nginx", "tier": "web"})).To(gomega.BeTrue())
+	g.Expect(IsAppSelectingObject(app, SubscriptionGroupKind, map[string]string{"app": "redis"})).To(gomega.BeFalse())
+	g.Expect(IsAppSelectingObject(app, metav1.GroupKind{Kind: "ConfigMap"}, map[string]string{"app": "nginx"})).To(gomega.BeFalse())
+
+	// an invalid selector selects nothing
+	app.Spec.Selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Bad"}}}
+	g.Expect(IsAppSelectingObject(app, SubscriptionGroupKind, map[string]string{"app": "nginx"})).To(gomega.BeFalse())
+}
+
+func TestIsAppNamespaceAllowed(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1beta1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "app-ns"}}
+	g.Expect(IsCrossNamespaceApp(app)).To(gomega.BeFalse())
+
+	app.Annotations = map[string]string{AnnotationMemberNamespaceSelector: "team=payments"}
+	g.Expect(IsCrossNamespaceApp(app)).To(gomega.BeTrue())
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "member-ns"}}
+	g.Expect(IsAppNamespaceAllowed(ns, "app-ns")).To(gomega.BeFalse())
+
+	ns.Annotations = map[string]string{AnnotationAllowedAppNamespaces: "other-ns, app-ns"}
+	g.Expect(IsAppNamespaceAllowed(ns, "app-ns")).To(gomega.BeTrue())
+	g.Expect(IsAppNamespaceAllowed(ns, "tenant-ns")).To(gomega.BeFalse())
+
+	ns.Annotations = map[string]string{AnnotationAllowedAppNamespaces: "*"}
+	g.Expect(IsAppNamespaceAllowed(ns, "tenant-ns")).To(gomega.BeTrue())
+
+	// the application namespace is always allowed
+	g.Expect(IsAppNamespaceAllowed(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app-ns"}}, "app-ns")).To(gomega.BeTrue())
+}
+
+func TestGetAppDeletionPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1beta1.Application{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "app-ns", UID: "uid1"}}
+	g.Expect(GetAppDeletionPolicy(app)).To(gomega.Equal(DeletionPolicyOrphan))
+
+	app.Annotations = map[string]string{AnnotationDeletionPolicy: "Cascade"}
+	g.Expect(GetAppDeletionPolicy(app)).To(gomega.Equal(DeletionPolicyCascade))
+
+	app.Annotations = map[string]string{AnnotationDeletionPolicy: "unknown"}
+	g.Expect(GetAppDeletionPolicy(app)).To(gomega.Equal(DeletionPolicyOrphan))
+
+	ref := metav1.OwnerReference{APIVersion: "app.k8s.io/v1beta1", Kind: "Application", Name: "app1", UID: "uid1"}
+	g.Expect(IsAppOwnerReference(ref, app)).To(gomega.BeTrue())
+
+	ref.UID = "uid2"
+	g.Expect(IsAppOwnerReference(ref, app)).To(gomega.BeFalse())
+
+	ref = metav1.OwnerReference{APIVersion: "apps.open-cluster-management.io/v1", Kind: "Subscription", Name: "app1", UID: "uid1"}
+	g.Expect(IsAppOwnerReference(ref, app)).To(gomega.BeFalse())
+}
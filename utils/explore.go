@@ -16,24 +16,102 @@ package utils
 
 import (
 	"encoding/json"
+	"sort"
+	"strings"
 
 	dplv1alpha1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 	subv1alpha1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
 )
 
 const (
 	ResourceLabelName = "name"
 )
 
+const (
+	// ClusterPhaseDeployed all deployables are deployed on the cluster
+	ClusterPhaseDeployed = "Deployed"
+	// ClusterPhaseFailed some deployables failed on the cluster
+	ClusterPhaseFailed = "Failed"
+	// ClusterPhasePending some deployables are not reported by the cluster yet
+	ClusterPhasePending = "Pending"
+)
+
+const (
+	// AppConditionClustersResolved tells if the managed clusters of all application subscriptions are found
+	AppConditionClustersResolved appv1beta1.ConditionType = "ClustersResolved"
+
+	// ReasonClustersFound the managed clusters of all application subscriptions are found
+	ReasonClustersFound = "ClustersFound"
+	// ReasonClustersNotFound some application subscriptions have neither a hosting deployable nor a placement decision
+	ReasonClustersNotFound = "ClustersNotFound"
+)
+
 // DplMap store all dpl names for a cluster [dplName]
 type DplMap struct {
 	DplResourceMap map[string]*dplv1alpha1.Deployable
+	// DplPhaseMap store the phase of each dpl on the cluster [dplName]
+	DplPhaseMap map[string]dplv1alpha1.DeployablePhase
+}
+
+// ClusterStatus is the deployment of the application on one managed cluster
+type ClusterStatus struct {
+	Name          string   `json:"name"`
+	Deployables   []string `json:"deployables,omitempty"`
+	TemplateKinds []string `json:"templateKinds,omitempty"`
+	Phase         string   `json:"phase,omitempty"`
+}
+
+// GetHostingSubscription returns the "namespace/name" of the subscription deployed by the hosting deployable,
+// from its subscription owner reference or its hosting-subscription annotation. Empty if the deployable hosts no subscription.
+func GetHostingSubscription(dpl *dplv1alpha1.Deployable) string {
+	for _, owner := range dpl.GetOwnerReferences() {
+		gv, err := schema.ParseGroupVersion(owner.APIVersion)
+		if err == nil && gv.Group == subv1alpha1.SchemeGroupVersion.Group && owner.Kind == "Subscription" {
+			return dpl.Namespace + "/" + owner.Name
+		}
+	}
+
+	hosting := dpl.GetAnnotations()[dplv1alpha1.AnnotationSubscription]
+	if hosting == "" {
+		return ""
+	}
+
+	if !strings.Contains(hosting, "/") {
+		return dpl.Namespace + "/" + hosting
+	}
+
+	return hosting
 }
 
-// GetUniqueDeployables get unique deployable array
+// SetAppClustersCondition reports the application subscriptions whose managed clusters can't be found.
+// The condition is removed from the applications without subscription.
+func SetAppClustersCondition(status *appv1beta1.ApplicationStatus, subCount int, unresolvedSubs []string) {
+	if subCount == 0 {
+		RemoveAppCondition(status, AppConditionClustersResolved)
+		return
+	}
+
+	if len(unresolvedSubs) > 0 {
+		sort.Strings(unresolvedSubs)
+
+		SetAppCondition(status, AppConditionClustersResolved, corev1.ConditionFalse, ReasonClustersNotFound,
+			"No hosting deployable or placement decision found for subscriptions: "+strings.Join(unresolvedSubs, ","))
+
+		return
+	}
+
+	SetAppCondition(status, AppConditionClustersResolved, corev1.ConditionTrue, ReasonClustersFound,
+		"The managed clusters of all subscriptions are found")
+}
+
+// GetUniqueDeployables get unique deployable array, sorted by namespace and name
 func GetUniqueDeployables(allDpls []*dplv1alpha1.Deployable) []*dplv1alpha1.Deployable {
 	dplmap := make(map[string]*dplv1alpha1.Deployable)
 
@@ -47,10 +125,12 @@ func GetUniqueDeployables(allDpls []*dplv1alpha1.Deployable) []*dplv1alpha1.Depl
 		newdpls = append(newdpls, newdpl.DeepCopy())
 	}
 
+	sortByNamespacedName(newdpls)
+
 	return newdpls
 }
 
-// GetUniqueSubscriptions get unique subscription array
+// GetUniqueSubscriptions get unique subscription array, sorted by namespace and name
 func GetUniqueSubscriptions(allSubs []*subv1alpha1.Subscription) []*subv1alpha1.Subscription {
 	submap := make(map[string]*subv1alpha1.Subscription)
 
@@ -64,43 +144,138 @@ func GetUniqueSubscriptions(allSubs []*subv1alpha1.Subscription) []*subv1alpha1.
 		newsubs = append(newsubs, newsub.DeepCopy())
 	}
 
+	sortByNamespacedName(newsubs)
+
 	return newsubs
 }
 
+// sortByNamespacedName sorts the objects by namespace and name, so the membership output doesn't depend on the map order
+func sortByNamespacedName[T metav1.Object](objs []T) {
+	sort.Slice(objs, func(i, j int) bool {
+		if objs[i].GetNamespace() != objs[j].GetNamespace() {
+			return objs[i].GetNamespace() < objs[j].GetNamespace()
+		}
+
+		return objs[i].GetName() < objs[j].GetName()
+	})
+}
+
 // PrintAllClusterDplMap print all cluster deployable map
 func PrintAllClusterDplMap(allClusterDplMap map[string]*DplMap) {
 	for cluster, dplmap := range allClusterDplMap {
 		for dplname, dpl := range dplmap.DplResourceMap {
-			template := &unstructured.Unstructured{}
-			templateKind := ""
+			klog.V(1).Infof("cluster: %#v, dpl: %#v, dpl template kind: %#v", cluster, dplname, getTemplateKind(dpl))
+		}
+	}
+}
 
-			if dpl.Spec.Template != nil {
-				err := json.Unmarshal(dpl.Spec.Template.Raw, template)
-				if err == nil {
-					templateKind = template.GetKind()
-				}
+// GetClusterStatuses converts the cluster deployable map to the per-cluster application status, sorted by cluster name
+func GetClusterStatuses(allClusterDplMap map[string]*DplMap) []ClusterStatus {
+	clusterStatuses := make([]ClusterStatus, 0, len(allClusterDplMap))
+
+	for cluster, dplmap := range allClusterDplMap {
+		clusterStatus := ClusterStatus{
+			Name:  cluster,
+			Phase: ClusterPhaseDeployed,
+		}
+
+		kinds := make(map[string]bool)
+
+		for dplname, dpl := range dplmap.DplResourceMap {
+			clusterStatus.Deployables = append(clusterStatus.Deployables, dpl.Namespace+"/"+dpl.Name)
+
+			if kind := getTemplateKind(dpl); kind != "" && !kinds[kind] {
+				kinds[kind] = true
+				clusterStatus.TemplateKinds = append(clusterStatus.TemplateKinds, kind)
 			}
 
-			klog.V(1).Infof("cluster: %#v, dpl: %#v, dpl template kind: %#v", cluster, dplname, templateKind)
+			switch dplmap.DplPhaseMap[dplname] {
+			case dplv1alpha1.DeployableFailed:
+				clusterStatus.Phase = ClusterPhaseFailed
+			case dplv1alpha1.DeployableUnknown:
+				if clusterStatus.Phase != ClusterPhaseFailed {
+					clusterStatus.Phase = ClusterPhasePending
+				}
+			}
 		}
+
+		sort.Strings(clusterStatus.Deployables)
+		sort.Strings(clusterStatus.TemplateKinds)
+
+		clusterStatuses = append(clusterStatuses, clusterStatus)
+	}
+
+	sort.Slice(clusterStatuses, func(i, j int) bool {
+		return clusterStatuses[i].Name < clusterStatuses[j].Name
+	})
+
+	return clusterStatuses
+}
+
+// getTemplateKind returns the kind of the deployable template, empty if it can't be told
+func getTemplateKind(dpl *dplv1alpha1.Deployable) string {
+	if dpl.Spec.Template == nil {
+		return ""
 	}
+
+	template := &unstructured.Unstructured{}
+
+	if err := json.Unmarshal(dpl.Spec.Template.Raw, template); err != nil {
+		return ""
+	}
+
+	return template.GetKind()
 }
 
 // AppendClusterDplMap append dpl and its deployed cluster to allClusterDplMap
 func AppendClusterDplMap(statusdpl dplv1alpha1.Deployable, dpl dplv1alpha1.Deployable, allClusterDplMap map[string]*DplMap) {
 	if statusdpl.Status.Phase == "Propagated" || statusdpl.Status.Phase == "Deployed" {
-		for cluster := range statusdpl.Status.PropagatedStatus {
-			dplmap, ok := allClusterDplMap[cluster]
+		for cluster, clusterStatus := range statusdpl.Status.PropagatedStatus {
+			phase := dplv1alpha1.DeployableUnknown
+			if clusterStatus != nil {
+				phase = clusterStatus.Phase
+			}
+
+			appendClusterDpl(cluster, dpl, phase, allClusterDplMap)
+		}
+	}
+}
 
-			if !ok {
-				// register new dpl name
-				dplmap = &DplMap{
-					DplResourceMap: make(map[string]*dplv1alpha1.Deployable),
+// AppendSubscriptionClusterDplMap append dpl and the clusters decided by the subscription placement to allClusterDplMap.
+// The phase on each cluster comes from the package statuses the subscription reports for the cluster.
+func AppendSubscriptionClusterDplMap(sub *subv1alpha1.Subscription, clusters []string, dpl dplv1alpha1.Deployable,
+	allClusterDplMap map[string]*DplMap) {
+	for _, cluster := range clusters {
+		phase := dplv1alpha1.DeployableUnknown
+
+		if clusterStatus := sub.Status.Statuses[cluster]; clusterStatus != nil && len(clusterStatus.SubscriptionPackageStatus) > 0 {
+			phase = dplv1alpha1.DeployableDeployed
+
+			for _, pkgStatus := range clusterStatus.SubscriptionPackageStatus {
+				if pkgStatus != nil && pkgStatus.Phase == subv1alpha1.SubscriptionFailed {
+					phase = dplv1alpha1.DeployableFailed
+					break
 				}
 			}
+		}
+
+		appendClusterDpl(cluster, dpl, phase, allClusterDplMap)
+	}
+}
 
-			dplmap.DplResourceMap[dpl.Name] = dpl.DeepCopy()
-			allClusterDplMap[cluster] = dplmap
+// appendClusterDpl registers the dpl and its phase on the cluster
+func appendClusterDpl(cluster string, dpl dplv1alpha1.Deployable, phase dplv1alpha1.DeployablePhase, allClusterDplMap map[string]*DplMap) {
+	dplmap, ok := allClusterDplMap[cluster]
+
+	if !ok {
+		// register new dpl name
+		dplmap = &DplMap{
+			DplResourceMap: make(map[string]*dplv1alpha1.Deployable),
+			DplPhaseMap:    make(map[string]dplv1alpha1.DeployablePhase),
 		}
 	}
+
+	dplmap.DplResourceMap[dpl.Name] = dpl.DeepCopy()
+	dplmap.DplPhaseMap[dpl.Name] = phase
+	allClusterDplMap[cluster] = dplmap
 }
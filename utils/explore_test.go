@@ -15,6 +15,7 @@
 package utils
 
 import (
+	"encoding/json"
 	"testing"
 
 	deployablev1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
@@ -23,6 +24,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
 )
 
 var (
@@ -74,4 +76,134 @@ func TestGetUniqueSubscriptions(t *testing.T) {
 
 	sub := GetUniqueSubscriptions(subArray)
 	assert.Equal(t, sub[0].GetName(), instance.GetName())
+
+	// the unique subscriptions are sorted by namespace and name, whatever the input order
+	subArray = []*subv1.Subscription{
+		{ObjectMeta: metav1.ObjectMeta{Name: "sub-b", Namespace: "ns2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sub-b", Namespace: "ns1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sub-a", Namespace: "ns2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "sub-b", Namespace: "ns1"}},
+	}
+
+	sub = GetUniqueSubscriptions(subArray)
+	assert.Equal(t, 3, len(sub))
+	assert.Equal(t, "ns1/sub-b", sub[0].Namespace+"/"+sub[0].Name)
+	assert.Equal(t, "ns2/sub-a", sub[1].Namespace+"/"+sub[1].Name)
+	assert.Equal(t, "ns2/sub-b", sub[2].Namespace+"/"+sub[2].Name)
+}
+
+func TestGetClusterStatuses(t *testing.T) {
+	template, _ := json.Marshal(payload)
+
+	hostdpl := deployablev1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sub-deployable",
+			Namespace: dplns,
+		},
+		Status: deployablev1.DeployableStatus{
+			ResourceUnitStatus: deployablev1.ResourceUnitStatus{Phase: deployablev1.DeployablePropagated},
+			PropagatedStatus: map[string]*deployablev1.ResourceUnitStatus{
+				"cluster2": {Phase: deployablev1.DeployableFailed},
+				"cluster1": {Phase: deployablev1.DeployableDeployed},
+			},
+		},
+	}
+
+	dpl := deployablev1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dplname,
+			Namespace: dplns,
+		},
+		Spec: deployablev1.DeployableSpec{
+			Template: &runtime.RawExtension{
+				Raw: template,
+			},
+		},
+	}
+
+	allClusterDplMap := make(map[string]*DplMap)
+	AppendClusterDplMap(hostdpl, dpl, allClusterDplMap)
+
+	clusters := GetClusterStatuses(allClusterDplMap)
+	assert.Equal(t, []ClusterStatus{
+		{
+			Name:          "cluster1",
+			Deployables:   []string{dplns + "/" + dplname},
+			TemplateKinds: []string{"ConfigMap"},
+			Phase:         ClusterPhaseDeployed,
+		},
+		{
+			Name:          "cluster2",
+			Deployables:   []string{dplns + "/" + dplname},
+			TemplateKinds: []string{"ConfigMap"},
+			Phase:         ClusterPhaseFailed,
+		},
+	}, clusters)
+}
+
+func TestGetHostingSubscription(t *testing.T) {
+	dpl := &deployablev1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: "hosting", Namespace: dplns}}
+	assert.Equal(t, "", GetHostingSubscription(dpl))
+
+	dpl.Annotations = map[string]string{deployablev1.AnnotationSubscription: "sub-ns/sub1"}
+	assert.Equal(t, "sub-ns/sub1", GetHostingSubscription(dpl))
+
+	dpl.Annotations = map[string]string{deployablev1.AnnotationSubscription: "sub1"}
+	assert.Equal(t, dplns+"/sub1", GetHostingSubscription(dpl))
+
+	// the owner reference wins over the annotation
+	dpl.OwnerReferences = []metav1.OwnerReference{
+		{APIVersion: "v1", Kind: "ConfigMap", Name: "cm"},
+		{APIVersion: subv1.SchemeGroupVersion.String(), Kind: "Subscription", Name: "sub2"},
+	}
+	assert.Equal(t, dplns+"/sub2", GetHostingSubscription(dpl))
+}
+
+func TestSetAppClustersCondition(t *testing.T) {
+	status := &appv1beta1.ApplicationStatus{}
+
+	SetAppClustersCondition(status, 2, []string{"ns/sub2", "ns/sub1"})
+
+	cond := GetAppCondition(status, AppConditionClustersResolved)
+	assert.NotNil(t, cond)
+	assert.Equal(t, corev1.ConditionFalse, cond.Status)
+	assert.Equal(t, ReasonClustersNotFound, cond.Reason)
+	assert.Equal(t, "No hosting deployable or placement decision found for subscriptions: ns/sub1,ns/sub2", cond.Message)
+
+	SetAppClustersCondition(status, 2, nil)
+	assert.Equal(t, corev1.ConditionTrue, GetAppCondition(status, AppConditionClustersResolved).Status)
+
+	SetAppClustersCondition(status, 0, nil)
+	assert.Nil(t, GetAppCondition(status, AppConditionClustersResolved))
+}
+
+func TestAppendSubscriptionClusterDplMap(t *testing.T) {
+	sub := &subv1.Subscription{
+		Status: subv1.SubscriptionStatus{
+			Statuses: subv1.SubscriptionClusterStatusMap{
+				"cluster1": &subv1.SubscriptionPerClusterStatus{
+					SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+						"pkg": {Phase: subv1.SubscriptionSubscribed},
+					},
+				},
+				"cluster2": &subv1.SubscriptionPerClusterStatus{
+					SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+						"pkg": {Phase: subv1.SubscriptionFailed},
+					},
+				},
+			},
+		},
+	}
+
+	dpl := deployablev1.Deployable{ObjectMeta: metav1.ObjectMeta{Name: dplname, Namespace: dplns}}
+
+	allClusterDplMap := make(map[string]*DplMap)
+	AppendSubscriptionClusterDplMap(sub, []string{"cluster1", "cluster2", "cluster3"}, dpl, allClusterDplMap)
+
+	clusters := GetClusterStatuses(allClusterDplMap)
+	assert.Equal(t, []ClusterStatus{
+		{Name: "cluster1", Deployables: []string{dplns + "/" + dplname}, Phase: ClusterPhaseDeployed},
+		{Name: "cluster2", Deployables: []string{dplns + "/" + dplname}, Phase: ClusterPhaseFailed},
+		{Name: "cluster3", Deployables: []string{dplns + "/" + dplname}, Phase: ClusterPhasePending},
+	}, clusters)
 }
@@ -0,0 +1,384 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+const (
+	// AppConditionDegraded some components of the application failed
+	AppConditionDegraded appv1beta1.ConditionType = "Degraded"
+	// AppConditionProgressing some components of the application are still being deployed
+	AppConditionProgressing appv1beta1.ConditionType = "Progressing"
+
+	// ReasonComponentsHealthy no component of the application failed
+	ReasonComponentsHealthy = "ComponentsHealthy"
+	// ReasonComponentsSettled no component of the application is being deployed
+	ReasonComponentsSettled = "ComponentsSettled"
+
+	// AppConditionAssembled tells the reason of the application spec.assemblyPhase
+	AppConditionAssembled appv1beta1.ConditionType = "Assembled"
+	// ReasonAssemblySucceeded every component kind of the application has a ready component
+	ReasonAssemblySucceeded = "AssemblySucceeded"
+	// ReasonAssemblyPending some component kinds of the application have no ready component yet
+	ReasonAssemblyPending = "AssemblyPending"
+	// ReasonAssemblyFailed all components of some component kinds of the application failed
+	ReasonAssemblyFailed = "AssemblyFailed"
+
+	// maxReportedComponents caps the number of components named in a condition message
+	maxReportedComponents = 5
+)
+
+// ComponentHealth is the health of one application component
+type ComponentHealth struct {
+	appv1beta1.ObjectStatus
+	// Message tells why the component is not ready
+	Message string
+}
+
+// GetSubscriptionHealth rolls up the subscription phase and its per-cluster package phases
+func GetSubscriptionHealth(sub *subv1.Subscription) ComponentHealth {
+	health := ComponentHealth{
+		ObjectStatus: appv1beta1.ObjectStatus{
+			Link:  fmt.Sprintf("/apis/%s/namespaces/%s/subscriptions/%s", subv1.SchemeGroupVersion.String(), sub.Namespace, sub.Name),
+			Name:  sub.Namespace + "/" + sub.Name,
+			Kind:  "Subscription",
+			Group: subv1.SchemeGroupVersion.Group,
+		},
+	}
+
+	switch sub.Status.Phase {
+	case subv1.SubscriptionFailed, subv1.SubscriptionPropagationFailed:
+		health.Status = ComponentStatusFailed
+		health.Message = joinReason(string(sub.Status.Phase), sub.Status.Reason, sub.Status.Message)
+
+		return health
+	case subv1.SubscriptionPropagated, subv1.SubscriptionSubscribed:
+		health.Status = ComponentStatusReady
+	case subv1.SubscriptionUnknown:
+		health.Status = ComponentStatusUnknown
+		health.Message = "not processed yet"
+	default:
+		health.Status = ComponentStatusInProgress
+		health.Message = string(sub.Status.Phase)
+	}
+
+	var failedClusters []string
+
+	for cluster, clusterStatus := range sub.Status.Statuses {
+		if clusterStatus == nil {
+			continue
+		}
+
+		for _, pkgStatus := range clusterStatus.SubscriptionPackageStatus {
+			if pkgStatus != nil && pkgStatus.Phase == subv1.SubscriptionFailed {
+				failedClusters = append(failedClusters, cluster)
+				break
+			}
+		}
+	}
+
+	if len(failedClusters) > 0 {
+		sort.Strings(failedClusters)
+
+		health.Status = ComponentStatusFailed
+		health.Message = "failed on clusters " + strings.Join(failedClusters, ",")
+	}
+
+	return health
+}
+
+// GetDeployableHealth rolls up the deployable phase and its propagated cluster phases
+func GetDeployableHealth(dpl *dplv1.Deployable) ComponentHealth {
+	health := ComponentHealth{
+		ObjectStatus: appv1beta1.ObjectStatus{
+			Link:  fmt.Sprintf("/apis/%s/namespaces/%s/deployables/%s", dplv1.SchemeGroupVersion.String(), dpl.Namespace, dpl.Name),
+			Name:  dpl.Namespace + "/" + dpl.Name,
+			Kind:  "Deployable",
+			Group: dplv1.SchemeGroupVersion.Group,
+		},
+	}
+
+	switch dpl.Status.Phase {
+	case dplv1.DeployableFailed:
+		health.Status = ComponentStatusFailed
+		health.Message = joinReason(string(dpl.Status.Phase), dpl.Status.Reason, dpl.Status.Message)
+
+		return health
+	case dplv1.DeployableDeployed, dplv1.DeployablePropagated:
+		health.Status = ComponentStatusReady
+	default:
+		health.Status = ComponentStatusUnknown
+		health.Message = "not processed yet"
+	}
+
+	var failedClusters, pendingClusters []string
+
+	for cluster, clusterStatus := range dpl.Status.PropagatedStatus {
+		if clusterStatus == nil {
+			continue
+		}
+
+		switch clusterStatus.Phase {
+		case dplv1.DeployableFailed:
+			failedClusters = append(failedClusters, cluster)
+		case dplv1.DeployableUnknown:
+			pendingClusters = append(pendingClusters, cluster)
+		}
+	}
+
+	if len(failedClusters) > 0 {
+		sort.Strings(failedClusters)
+
+		health.Status = ComponentStatusFailed
+		health.Message = "failed on clusters " + strings.Join(failedClusters, ",")
+
+		return health
+	}
+
+	if len(pendingClusters) > 0 && health.Status == ComponentStatusReady {
+		sort.Strings(pendingClusters)
+
+		health.Status = ComponentStatusInProgress
+		health.Message = "pending on clusters " + strings.Join(pendingClusters, ",")
+	}
+
+	return health
+}
+
+// GetUnstructuredHealth tells the health of a component of any other kind from its status conditions.
+// A Ready, Available or Deployed condition tells if the component is ready, a true *Failed/*Failure condition means failed.
+// The component is ready if it has no such condition, e.g. ConfigMap or Service.
+func GetUnstructuredHealth(obj *unstructured.Unstructured, link string) ComponentHealth {
+	gvk := obj.GroupVersionKind()
+
+	health := ComponentHealth{
+		ObjectStatus: appv1beta1.ObjectStatus{
+			Link:   link,
+			Name:   obj.GetNamespace() + "/" + obj.GetName(),
+			Kind:   gvk.Kind,
+			Group:  gvk.Group,
+			Status: ComponentStatusReady,
+		},
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		condType, _ := cond["type"].(string)
+		condStatus, _ := cond["status"].(string)
+		condMessage, _ := cond["message"].(string)
+
+		switch {
+		case (strings.HasSuffix(condType, "Failed") || strings.HasSuffix(condType, "Failure")) &&
+			condStatus == string(corev1.ConditionTrue):
+			health.Status = ComponentStatusFailed
+			health.Message = joinReason(condType, condMessage)
+
+			return health
+		case condType == "Ready" || condType == "Available" || condType == "Deployed":
+			if condStatus != string(corev1.ConditionTrue) {
+				health.Status = ComponentStatusInProgress
+				health.Message = joinReason(condType+" is "+condStatus, condMessage)
+			}
+		}
+	}
+
+	return health
+}
+
+// GetComponentHealth gets the health of all application components, subscriptions first
+func GetComponentHealth(allSubs []*subv1.Subscription, allDpls []*dplv1.Deployable) []ComponentHealth {
+	healths := make([]ComponentHealth, 0, len(allSubs)+len(allDpls))
+
+	for _, sub := range allSubs {
+		healths = append(healths, GetSubscriptionHealth(sub))
+	}
+
+	for _, dpl := range allDpls {
+		healths = append(healths, GetDeployableHealth(dpl))
+	}
+
+	return healths
+}
+
+// SortComponentHealth sorts the component health by group, kind and name, so the component list is in a canonical order
+func SortComponentHealth(healths []ComponentHealth) {
+	sort.SliceStable(healths, func(i, j int) bool {
+		if healths[i].Group != healths[j].Group {
+			return healths[i].Group < healths[j].Group
+		}
+
+		if healths[i].Kind != healths[j].Kind {
+			return healths[i].Kind < healths[j].Kind
+		}
+
+		return healths[i].Name < healths[j].Name
+	})
+}
+
+// SetAppHealthConditions aggregates the component health into the Ready, Degraded and Progressing conditions
+func SetAppHealthConditions(status *appv1beta1.ApplicationStatus, healths []ComponentHealth) {
+	var failed, progressing []ComponentHealth
+
+	ready := 0
+
+	for _, health := range healths {
+		switch health.Status {
+		case ComponentStatusReady:
+			ready++
+		case ComponentStatusFailed:
+			failed = append(failed, health)
+		default:
+			progressing = append(progressing, health)
+		}
+	}
+
+	if len(failed) > 0 {
+		SetAppCondition(status, AppConditionDegraded, corev1.ConditionTrue, failed[0].Kind+"Failed", describeComponents(failed))
+	} else {
+		SetAppCondition(status, AppConditionDegraded, corev1.ConditionFalse, ReasonComponentsHealthy, "No component failed")
+	}
+
+	if len(progressing) > 0 {
+		SetAppCondition(status, AppConditionProgressing, corev1.ConditionTrue, progressing[0].Kind+"Progressing", describeComponents(progressing))
+	} else {
+		SetAppCondition(status, AppConditionProgressing, corev1.ConditionFalse, ReasonComponentsSettled, "No component is being deployed")
+	}
+
+	switch {
+	case len(healths) == 0:
+		SetAppCondition(status, appv1beta1.Ready, corev1.ConditionFalse, ReasonNoComponents,
+			"No component is selected by the application")
+	case ready == len(healths):
+		SetAppCondition(status, appv1beta1.Ready, corev1.ConditionTrue, ReasonComponentsReady,
+			"All components are ready")
+	default:
+		SetAppCondition(status, appv1beta1.Ready, corev1.ConditionFalse, ReasonComponentsNotReady,
+			fmt.Sprintf("%d of %d components are ready", ready, len(healths)))
+	}
+}
+
+// SetAppAssemblyPhase drives the application spec.assemblyPhase from the component health, and tells why in the Assembled condition.
+// The application is assembled once every kind in componentKinds has a ready component. Without componentKinds, one ready
+// subscription or deployable is enough. The assembly fails when all components of a kind failed. An assembled application
+// doesn't go back to Pending, e.g. while its components are being redeployed.
+func SetAppAssemblyPhase(app *appv1beta1.Application, healths []ComponentHealth) {
+	kinds := app.Spec.ComponentGroupKinds
+	kindOf := func(health ComponentHealth) metav1.GroupKind {
+		return metav1.GroupKind{Group: health.Group, Kind: health.Kind}
+	}
+
+	if len(kinds) == 0 {
+		kinds = []metav1.GroupKind{{Kind: "Subscription or Deployable"}}
+		kindOf = func(ComponentHealth) metav1.GroupKind { return kinds[0] }
+	}
+
+	ready := make(map[metav1.GroupKind]int)
+	failed := make(map[metav1.GroupKind]int)
+	total := make(map[metav1.GroupKind]int)
+
+	for _, health := range healths {
+		gk := kindOf(health)
+		total[gk]++
+
+		switch health.Status {
+		case ComponentStatusReady:
+			ready[gk]++
+		case ComponentStatusFailed:
+			failed[gk]++
+		}
+	}
+
+	var pendingKinds, failedKinds []string
+
+	for _, gk := range kinds {
+		switch {
+		case ready[gk] > 0:
+		case total[gk] > 0 && failed[gk] == total[gk]:
+			failedKinds = append(failedKinds, gk.String())
+		default:
+			pendingKinds = append(pendingKinds, gk.String())
+		}
+	}
+
+	switch {
+	case len(failedKinds) > 0:
+		app.Spec.AssemblyPhase = appv1beta1.Failed
+		SetAppCondition(&app.Status, AppConditionAssembled, corev1.ConditionFalse, ReasonAssemblyFailed,
+			"All components failed for kinds: "+strings.Join(failedKinds, ", "))
+	case len(pendingKinds) > 0:
+		if app.Spec.AssemblyPhase == appv1beta1.Succeeded {
+			return
+		}
+
+		app.Spec.AssemblyPhase = appv1beta1.Pending
+		SetAppCondition(&app.Status, AppConditionAssembled, corev1.ConditionFalse, ReasonAssemblyPending,
+			"No ready component yet for kinds: "+strings.Join(pendingKinds, ", "))
+	default:
+		app.Spec.AssemblyPhase = appv1beta1.Succeeded
+		SetAppCondition(&app.Status, AppConditionAssembled, corev1.ConditionTrue, ReasonAssemblySucceeded,
+			"Every component kind has a ready component")
+	}
+}
+
+// describeComponents names the components and why they are not ready, e.g. "Subscription ns/sub: failed on clusters c1"
+func describeComponents(healths []ComponentHealth) string {
+	var msgs []string
+
+	for i, health := range healths {
+		if i == maxReportedComponents {
+			msgs = append(msgs, fmt.Sprintf("and %d more", len(healths)-maxReportedComponents))
+			break
+		}
+
+		msg := health.Kind + " " + health.Name
+		if health.Message != "" {
+			msg += ": " + health.Message
+		}
+
+		msgs = append(msgs, msg)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// joinReason joins the non-empty phase, reason and message of a component status
+func joinReason(parts ...string) string {
+	var nonEmpty []string
+
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+
+	return strings.Join(nonEmpty, ", ")
+}
@@ -0,0 +1,237 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+func TestGetDeployableHealth(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dpl := &dplv1.Deployable{
+		ObjectMeta: metav1.ObjectMeta{Name: "dpl1", Namespace: "ns1"},
+		Status: dplv1.DeployableStatus{
+			ResourceUnitStatus: dplv1.ResourceUnitStatus{Phase: dplv1.DeployablePropagated},
+			PropagatedStatus: map[string]*dplv1.ResourceUnitStatus{
+				"cluster1": {Phase: dplv1.DeployableDeployed},
+				"cluster2": {Phase: dplv1.DeployableUnknown},
+			},
+		},
+	}
+
+	health := GetDeployableHealth(dpl)
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusInProgress))
+	g.Expect(health.Message).To(gomega.Equal("pending on clusters cluster2"))
+
+	dpl.Status.PropagatedStatus["cluster3"] = &dplv1.ResourceUnitStatus{Phase: dplv1.DeployableFailed}
+
+	health = GetDeployableHealth(dpl)
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusFailed))
+	g.Expect(health.Message).To(gomega.Equal("failed on clusters cluster3"))
+
+	dpl.Status.Phase = dplv1.DeployableFailed
+	dpl.Status.Reason = "bad template"
+
+	health = GetDeployableHealth(dpl)
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusFailed))
+	g.Expect(health.Message).To(gomega.Equal("Failed, bad template"))
+}
+
+func TestGetSubscriptionHealth(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := &subv1.Subscription{
+		ObjectMeta: metav1.ObjectMeta{Name: "sub1", Namespace: "ns1"},
+		Status: subv1.SubscriptionStatus{
+			Phase: subv1.SubscriptionPropagated,
+			Statuses: subv1.SubscriptionClusterStatusMap{
+				"cluster1": {
+					SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+						"pkg1": {Phase: subv1.SubscriptionSubscribed},
+					},
+				},
+			},
+		},
+	}
+
+	health := GetSubscriptionHealth(sub)
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusReady))
+
+	sub.Status.Statuses["cluster2"] = &subv1.SubscriptionPerClusterStatus{
+		SubscriptionPackageStatus: map[string]*subv1.SubscriptionUnitStatus{
+			"pkg1": {Phase: subv1.SubscriptionFailed},
+		},
+	}
+
+	health = GetSubscriptionHealth(sub)
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusFailed))
+	g.Expect(health.Message).To(gomega.Equal("failed on clusters cluster2"))
+}
+
+func TestSetAppHealthConditions(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	healths := GetComponentHealth(
+		[]*subv1.Subscription{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "sub1", Namespace: "ns1"},
+				Status:     subv1.SubscriptionStatus{Phase: subv1.SubscriptionPropagationFailed, Reason: "no channel"},
+			},
+		},
+		[]*dplv1.Deployable{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "dpl1", Namespace: "ns1"},
+			},
+		},
+	)
+
+	status := &appv1beta1.ApplicationStatus{}
+	SetAppHealthConditions(status, healths)
+
+	degraded := GetAppCondition(status, AppConditionDegraded)
+	g.Expect(degraded.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(degraded.Reason).To(gomega.Equal("SubscriptionFailed"))
+	g.Expect(degraded.Message).To(gomega.Equal("Subscription ns1/sub1: PropagationFailed, no channel"))
+
+	progressing := GetAppCondition(status, AppConditionProgressing)
+	g.Expect(progressing.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(progressing.Reason).To(gomega.Equal("DeployableProgressing"))
+
+	ready := GetAppCondition(status, appv1beta1.Ready)
+	g.Expect(ready.Status).To(gomega.Equal(corev1.ConditionFalse))
+	g.Expect(ready.Message).To(gomega.Equal("0 of 2 components are ready"))
+
+	SetAppHealthConditions(status, nil)
+
+	g.Expect(GetAppCondition(status, AppConditionDegraded).Status).To(gomega.Equal(corev1.ConditionFalse))
+	g.Expect(GetAppCondition(status, AppConditionProgressing).Status).To(gomega.Equal(corev1.ConditionFalse))
+	g.Expect(GetAppCondition(status, appv1beta1.Ready).Reason).To(gomega.Equal(ReasonNoComponents))
+}
+
+func TestSortComponentHealth(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	healths := []ComponentHealth{
+		{ObjectStatus: appv1beta1.ObjectStatus{Name: "ns1/sub2", Kind: "Subscription", Group: "apps.open-cluster-management.io"}},
+		{ObjectStatus: appv1beta1.ObjectStatus{Name: "ns1/cm1", Kind: "ConfigMap"}},
+		{ObjectStatus: appv1beta1.ObjectStatus{Name: "ns1/sub1", Kind: "Subscription", Group: "apps.open-cluster-management.io"}},
+		{ObjectStatus: appv1beta1.ObjectStatus{Name: "ns1/dpl1", Kind: "Deployable", Group: "apps.open-cluster-management.io"}},
+	}
+
+	SortComponentHealth(healths)
+
+	var names []string
+	for _, health := range healths {
+		names = append(names, health.Name)
+	}
+
+	g.Expect(names).To(gomega.Equal([]string{"ns1/cm1", "ns1/dpl1", "ns1/sub1", "ns1/sub2"}))
+}
+
+func TestSetAppAssemblyPhase(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := ComponentHealth{ObjectStatus: appv1beta1.ObjectStatus{
+		Name: "ns1/sub1", Kind: SubscriptionGroupKind.Kind, Group: SubscriptionGroupKind.Group, Status: ComponentStatusInProgress,
+	}}
+	cm := ComponentHealth{ObjectStatus: appv1beta1.ObjectStatus{Name: "ns1/cm1", Kind: "ConfigMap", Status: ComponentStatusReady}}
+
+	app := &appv1beta1.Application{}
+
+	// without componentKinds, one ready subscription or deployable assembles the application
+	SetAppAssemblyPhase(app, []ComponentHealth{sub})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.Pending))
+	g.Expect(GetAppCondition(&app.Status, AppConditionAssembled).Reason).To(gomega.Equal(ReasonAssemblyPending))
+
+	sub.Status = ComponentStatusReady
+	SetAppAssemblyPhase(app, []ComponentHealth{sub})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.ApplicationAssemblyPhase(appv1beta1.Succeeded)))
+
+	cond := GetAppCondition(&app.Status, AppConditionAssembled)
+	g.Expect(cond.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(gomega.Equal(ReasonAssemblySucceeded))
+
+	// every declared kind needs a ready component
+	app = &appv1beta1.Application{Spec: appv1beta1.ApplicationSpec{
+		ComponentGroupKinds: []metav1.GroupKind{SubscriptionGroupKind, {Kind: "ConfigMap"}},
+	}}
+
+	SetAppAssemblyPhase(app, []ComponentHealth{sub})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.Pending))
+	g.Expect(GetAppCondition(&app.Status, AppConditionAssembled).Message).To(gomega.Equal("No ready component yet for kinds: ConfigMap"))
+
+	SetAppAssemblyPhase(app, []ComponentHealth{sub, cm})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.ApplicationAssemblyPhase(appv1beta1.Succeeded)))
+
+	// an assembled application stays assembled while its components are redeployed
+	sub.Status = ComponentStatusInProgress
+	SetAppAssemblyPhase(app, []ComponentHealth{sub, cm})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.ApplicationAssemblyPhase(appv1beta1.Succeeded)))
+
+	// the assembly fails when all components of a kind failed
+	sub.Status = ComponentStatusFailed
+	SetAppAssemblyPhase(app, []ComponentHealth{sub, cm})
+	g.Expect(app.Spec.AssemblyPhase).To(gomega.Equal(appv1beta1.ApplicationAssemblyPhase(appv1beta1.Failed)))
+
+	cond = GetAppCondition(&app.Status, AppConditionAssembled)
+	g.Expect(cond.Reason).To(gomega.Equal(ReasonAssemblyFailed))
+	g.Expect(cond.Message).To(gomega.Equal("All components failed for kinds: Subscription.apps.open-cluster-management.io"))
+}
+
+func TestGetUnstructuredHealth(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetName("cm1")
+	obj.SetNamespace("ns1")
+
+	health := GetUnstructuredHealth(obj, "/api/v1/namespaces/ns1/configmaps/cm1")
+	g.Expect(health.Name).To(gomega.Equal("ns1/cm1"))
+	g.Expect(health.Kind).To(gomega.Equal("ConfigMap"))
+	g.Expect(health.Group).To(gomega.Equal(""))
+	g.Expect(health.Link).To(gomega.Equal("/api/v1/namespaces/ns1/configmaps/cm1"))
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusReady))
+
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	g.Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+		map[string]interface{}{"type": "Available", "status": "False", "message": "no replicas"},
+	}, "status", "conditions")).NotTo(gomega.HaveOccurred())
+
+	health = GetUnstructuredHealth(obj, "")
+	g.Expect(health.Group).To(gomega.Equal("apps"))
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusInProgress))
+	g.Expect(health.Message).To(gomega.Equal("Available is False, no replicas"))
+
+	g.Expect(unstructured.SetNestedSlice(obj.Object, []interface{}{
+		map[string]interface{}{"type": "Available", "status": "False"},
+		map[string]interface{}{"type": "ReplicaFailure", "status": "True", "message": "quota exceeded"},
+	}, "status", "conditions")).NotTo(gomega.HaveOccurred())
+
+	health = GetUnstructuredHealth(obj, "")
+	g.Expect(health.Status).To(gomega.Equal(ComponentStatusFailed))
+	g.Expect(health.Message).To(gomega.Equal("ReplicaFailure, quota exceeded"))
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+// ReasonMembershipChanged is the reason of the events listing the members joining or leaving the application
+const ReasonMembershipChanged = "MembershipChanged"
+
+// MemberDiff lists application members by kind
+type MemberDiff struct {
+	// Subscriptions as "namespace/name"
+	Subscriptions []string `json:"subscriptions,omitempty"`
+	// Deployables as "namespace/name"
+	Deployables []string `json:"deployables,omitempty"`
+	// Clusters the application is deployed to
+	Clusters []string `json:"clusters,omitempty"`
+	// Components of the other kinds as "Kind namespace/name"
+	Components []string `json:"components,omitempty"`
+}
+
+// MembershipChange is the last change of the application members, reported in status.lastMembershipChange
+type MembershipChange struct {
+	// Time of the change
+	Time metav1.Time `json:"time"`
+	// Added members joined the application
+	Added MemberDiff `json:"added,omitempty"`
+	// Removed members left the application
+	Removed MemberDiff `json:"removed,omitempty"`
+}
+
+// IsEmpty tells if the diff lists no member
+func (diff *MemberDiff) IsEmpty() bool {
+	return len(diff.Subscriptions)+len(diff.Deployables)+len(diff.Clusters)+len(diff.Components) == 0
+}
+
+// GetMembershipChange compares the old and new components and clusters of the application, nil if no member joined or left
+func GetMembershipChange(oldComponents, newComponents appv1beta1.ComponentList, oldClusters, newClusters []ClusterStatus) *MembershipChange {
+	oldMembers := getMemberDiff(oldComponents, oldClusters)
+	newMembers := getMemberDiff(newComponents, newClusters)
+
+	change := &MembershipChange{
+		Added: MemberDiff{
+			Subscriptions: subtractMembers(newMembers.Subscriptions, oldMembers.Subscriptions),
+			Deployables:   subtractMembers(newMembers.Deployables, oldMembers.Deployables),
+			Clusters:      subtractMembers(newMembers.Clusters, oldMembers.Clusters),
+			Components:    subtractMembers(newMembers.Components, oldMembers.Components),
+		},
+		Removed: MemberDiff{
+			Subscriptions: subtractMembers(oldMembers.Subscriptions, newMembers.Subscriptions),
+			Deployables:   subtractMembers(oldMembers.Deployables, newMembers.Deployables),
+			Clusters:      subtractMembers(oldMembers.Clusters, newMembers.Clusters),
+			Components:    subtractMembers(oldMembers.Components, newMembers.Components),
+		},
+	}
+
+	if change.Added.IsEmpty() && change.Removed.IsEmpty() {
+		return nil
+	}
+
+	change.Time = metav1.Now()
+
+	return change
+}
+
+// DescribeMembershipChange tells the members joining and leaving the application,
+// e.g. "added subscriptions: ns/sub1; removed clusters: cluster1"
+func DescribeMembershipChange(change *MembershipChange) string {
+	var msgs []string
+
+	for _, diff := range []struct {
+		verb string
+		diff MemberDiff
+	}{{"added", change.Added}, {"removed", change.Removed}} {
+		for _, kind := range []struct {
+			name    string
+			members []string
+		}{
+			{"subscriptions", diff.diff.Subscriptions},
+			{"deployables", diff.diff.Deployables},
+			{"clusters", diff.diff.Clusters},
+			{"components", diff.diff.Components},
+		} {
+			if len(kind.members) == 0 {
+				continue
+			}
+
+			members := kind.members
+			if len(members) > maxReportedComponents {
+				members = append(members[:maxReportedComponents:maxReportedComponents],
+					fmt.Sprintf("and %d more", len(kind.members)-maxReportedComponents))
+			}
+
+			msgs = append(msgs, diff.verb+" "+kind.name+": "+strings.Join(members, ", "))
+		}
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// GetMembershipChangePatch builds a json merge patch of status.lastMembershipChange
+func GetMembershipChangePatch(change *MembershipChange) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"lastMembershipChange": change,
+		},
+	})
+}
+
+// getMemberDiff lists all the components and clusters by kind
+func getMemberDiff(componentList appv1beta1.ComponentList, clusters []ClusterStatus) MemberDiff {
+	diff := MemberDiff{}
+
+	for _, obj := range componentList.Objects {
+		switch (metav1.GroupKind{Group: obj.Group, Kind: obj.Kind}) {
+		case SubscriptionGroupKind:
+			diff.Subscriptions = append(diff.Subscriptions, obj.Name)
+		case DeployableGroupKind:
+			diff.Deployables = append(diff.Deployables, obj.Name)
+		default:
+			diff.Components = append(diff.Components, obj.Kind+" "+obj.Name)
+		}
+	}
+
+	for _, cluster := range clusters {
+		diff.Clusters = append(diff.Clusters, cluster.Name)
+	}
+
+	return diff
+}
+
+// subtractMembers returns the sorted members not in others
+func subtractMembers(members, others []string) []string {
+	otherSet := make(map[string]bool, len(others))
+	for _, other := range others {
+		otherSet[other] = true
+	}
+
+	var result []string
+
+	for _, member := range members {
+		if !otherSet[member] {
+			result = append(result, member)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+func TestGetMembershipChange(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub1 := appv1beta1.ObjectStatus{Name: "ns1/sub1", Kind: "Subscription", Group: "apps.open-cluster-management.io"}
+	sub2 := appv1beta1.ObjectStatus{Name: "ns1/sub2", Kind: "Subscription", Group: "apps.open-cluster-management.io"}
+	dpl1 := appv1beta1.ObjectStatus{Name: "ns1/dpl1", Kind: "Deployable", Group: "apps.open-cluster-management.io"}
+	cm1 := appv1beta1.ObjectStatus{Name: "ns1/cm1", Kind: "ConfigMap", Status: ComponentStatusReady}
+
+	oldComponents := appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{sub1, dpl1}}
+	oldClusters := []ClusterStatus{{Name: "cluster1"}, {Name: "cluster2"}}
+
+	// the health of the members is not a membership change
+	sub1.Status = ComponentStatusFailed
+	g.Expect(GetMembershipChange(oldComponents, appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{dpl1, sub1}},
+		oldClusters, oldClusters)).To(gomega.BeNil())
+
+	newComponents := appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{sub1, sub2, cm1}}
+	newClusters := []ClusterStatus{{Name: "cluster2"}, {Name: "cluster3"}}
+
+	change := GetMembershipChange(oldComponents, newComponents, oldClusters, newClusters)
+	g.Expect(change).NotTo(gomega.BeNil())
+	g.Expect(change.Time.IsZero()).To(gomega.BeFalse())
+	g.Expect(change.Added).To(gomega.Equal(MemberDiff{
+		Subscriptions: []string{"ns1/sub2"},
+		Clusters:      []string{"cluster3"},
+		Components:    []string{"ConfigMap ns1/cm1"},
+	}))
+	g.Expect(change.Removed).To(gomega.Equal(MemberDiff{
+		Deployables: []string{"ns1/dpl1"},
+		Clusters:    []string{"cluster1"},
+	}))
+
+	g.Expect(DescribeMembershipChange(change)).To(gomega.Equal("added subscriptions: ns1/sub2; added clusters: cluster3; " +
+		"added components: ConfigMap ns1/cm1; removed deployables: ns1/dpl1; removed clusters: cluster1"))
+
+	// long lists are cut
+	change = &MembershipChange{Added: MemberDiff{Clusters: []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7"}}}
+	g.Expect(DescribeMembershipChange(change)).To(gomega.Equal("added clusters: c1, c2, c3, c4, c5, and 2 more"))
+	g.Expect(change.Added.Clusters).To(gomega.HaveLen(7))
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+const (
+	// ComponentStatusReady the component is deployed
+	ComponentStatusReady = "Ready"
+	// ComponentStatusInProgress the component is not deployed yet
+	ComponentStatusInProgress = "InProgress"
+	// ComponentStatusUnknown the component status can't be told
+	ComponentStatusUnknown = "Unknown"
+	// ComponentStatusFailed the component failed to deploy
+	ComponentStatusFailed = "Failed"
+
+	// ReasonComponentsReady all components of the application are ready
+	ReasonComponentsReady = "ComponentsReady"
+	// ReasonComponentsNotReady some components of the application are not ready
+	ReasonComponentsNotReady = "ComponentsNotReady"
+	// ReasonNoComponents no component is selected by the application
+	ReasonNoComponents = "NoComponents"
+
+	// AppConditionReconcileError the application components can't be read, the status keeps the last known membership
+	AppConditionReconcileError appv1beta1.ConditionType = "ReconcileError"
+	// ReasonComponentsNotRead the application components can't be read from the hub
+	ReasonComponentsNotRead = "ComponentsNotRead"
+)
+
+// GetComponentList builds the application component list from the component health
+func GetComponentList(healths []ComponentHealth) appv1beta1.ComponentList {
+	componentList := appv1beta1.ComponentList{}
+
+	for _, health := range healths {
+		componentList.Objects = append(componentList.Objects, health.ObjectStatus)
+	}
+
+	return componentList
+}
+
+// GetMembershipHash returns the sha256 of the component set, as "group/kind/name" lines in a canonical order,
+// where the component name is already "namespace/name".
+// It changes when a component joins or leaves the application, not when the component health changes.
+func GetMembershipHash(componentList appv1beta1.ComponentList) string {
+	keys := make([]string, 0, len(componentList.Objects))
+
+	for _, obj := range componentList.Objects {
+		keys = append(keys, obj.Group+"/"+obj.Kind+"/"+obj.Name)
+	}
+
+	sort.Strings(keys)
+
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// CountReadyComponents returns the number of ready components and the total number of components
+func CountReadyComponents(componentList appv1beta1.ComponentList) (int, int) {
+	ready := 0
+
+	for _, obj := range componentList.Objects {
+		if obj.Status == ComponentStatusReady {
+			ready++
+		}
+	}
+
+	return ready, len(componentList.Objects)
+}
+
+// GetAppCondition returns the application condition of the given type, nil if not found
+func GetAppCondition(status *appv1beta1.ApplicationStatus, condType appv1beta1.ConditionType) *appv1beta1.Condition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == condType {
+			return &status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// SetAppCondition adds or updates the application condition of the same type.
+// The timestamps are only moved when the condition really changes, so an unchanged condition doesn't trigger a status write.
+func SetAppCondition(status *appv1beta1.ApplicationStatus, condType appv1beta1.ConditionType,
+	condStatus corev1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
+
+	cond := GetAppCondition(status, condType)
+	if cond == nil {
+		status.Conditions = append(status.Conditions, appv1beta1.Condition{
+			Type:               condType,
+			Status:             condStatus,
+			Reason:             reason,
+			Message:            message,
+			LastUpdateTime:     now,
+			LastTransitionTime: now,
+		})
+
+		return
+	}
+
+	if cond.Status == condStatus && cond.Reason == reason && cond.Message == message {
+		return
+	}
+
+	if cond.Status != condStatus {
+		cond.LastTransitionTime = now
+	}
+
+	cond.Status = condStatus
+	cond.Reason = reason
+	cond.Message = message
+	cond.LastUpdateTime = now
+}
+
+// RemoveAppCondition removes the application condition of the given type
+func RemoveAppCondition(status *appv1beta1.ApplicationStatus, condType appv1beta1.ConditionType) {
+	var conditions []appv1beta1.Condition
+
+	for _, cond := range status.Conditions {
+		if cond.Type != condType {
+			conditions = append(conditions, cond)
+		}
+	}
+
+	status.Conditions = conditions
+}
+
+// IsAppStatusChanged tells if the application status needs to be written back
+func IsAppStatusChanged(oldStatus, newStatus *appv1beta1.ApplicationStatus) bool {
+	return !equality.Semantic.DeepEqual(oldStatus, newStatus)
+}
+
+// GetAppStatusPatch builds a json merge patch limited to the status fields owned by the application controller.
+// Empty fields are set to null, so they are cleared instead of being left behind by the merge.
+func GetAppStatusPatch(status *appv1beta1.ApplicationStatus, clusters []ClusterStatus) ([]byte, error) {
+	ownedStatus := map[string]interface{}{
+		"observedGeneration": status.ObservedGeneration,
+		"conditions":         nil,
+		"components":         nil,
+		"componentsReady":    nil,
+		"clusters":           nil,
+	}
+
+	if len(status.Conditions) > 0 {
+		ownedStatus["conditions"] = status.Conditions
+	}
+
+	if len(status.ComponentList.Objects) > 0 {
+		ownedStatus["components"] = status.ComponentList.Objects
+	}
+
+	if status.ComponentsReady != "" {
+		ownedStatus["componentsReady"] = status.ComponentsReady
+	}
+
+	if len(clusters) > 0 {
+		ownedStatus["clusters"] = clusters
+	}
+
+	return json.Marshal(map[string]interface{}{"status": ownedStatus})
+}
+
+// GetAppConditionsPatch builds a json merge patch of the application conditions only, leaving the other status fields as they are
+func GetAppConditionsPatch(status *appv1beta1.ApplicationStatus) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": status.Conditions,
+		},
+	})
+}
+
+// GetAppOwnedFieldsPatch builds a json merge patch of the application fields written by the controller: the membership
+// annotations and spec.assemblyPhase. The other annotations and spec fields are left to the users.
+func GetAppOwnedFieldsPatch(app *appv1beta1.Application) ([]byte, error) {
+	annotations := make(map[string]interface{})
+
+	for _, key := range appOwnedAnnotations {
+		annotations[key] = nil
+
+		if value, ok := app.GetAnnotations()[key]; ok {
+			annotations[key] = value
+		}
+	}
+
+	spec := map[string]interface{}{
+		"assemblyPhase": nil,
+	}
+
+	if app.Spec.AssemblyPhase != "" {
+		spec["assemblyPhase"] = app.Spec.AssemblyPhase
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+		"spec":     spec,
+	})
+}
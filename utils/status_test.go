@@ -0,0 +1,126 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	dplv1 "github.com/stolostron/multicloud-operators-application/pkg/apis/deployable/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	subv1 "open-cluster-management.io/multicloud-operators-subscription/pkg/apis/apps/v1"
+	appv1beta1 "sigs.k8s.io/application/api/v1beta1"
+)
+
+func TestGetComponentList(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	subs := []*subv1.Subscription{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sub1", Namespace: "ns1"},
+			Status:     subv1.SubscriptionStatus{Phase: subv1.SubscriptionPropagated},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "sub2", Namespace: "ns1"},
+			Status:     subv1.SubscriptionStatus{Phase: subv1.SubscriptionPropagationFailed},
+		},
+	}
+
+	dpls := []*dplv1.Deployable{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "dpl1", Namespace: "ns1"},
+		},
+	}
+
+	componentList := GetComponentList(GetComponentHealth(subs, dpls))
+	g.Expect(componentList.Objects).To(gomega.HaveLen(3))
+
+	g.Expect(componentList.Objects[0].Name).To(gomega.Equal("ns1/sub1"))
+	g.Expect(componentList.Objects[0].Kind).To(gomega.Equal("Subscription"))
+	g.Expect(componentList.Objects[0].Group).To(gomega.Equal("apps.open-cluster-management.io"))
+	g.Expect(componentList.Objects[0].Link).To(gomega.Equal("/apis/apps.open-cluster-management.io/v1/namespaces/ns1/subscriptions/sub1"))
+	g.Expect(componentList.Objects[0].Status).To(gomega.Equal(ComponentStatusReady))
+	g.Expect(componentList.Objects[1].Status).To(gomega.Equal(ComponentStatusFailed))
+	g.Expect(componentList.Objects[2].Kind).To(gomega.Equal("Deployable"))
+	g.Expect(componentList.Objects[2].Status).To(gomega.Equal(ComponentStatusUnknown))
+
+	ready, total := CountReadyComponents(componentList)
+	g.Expect(ready).To(gomega.Equal(1))
+	g.Expect(total).To(gomega.Equal(3))
+}
+
+func TestGetMembershipHash(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sub := appv1beta1.ObjectStatus{Name: "ns1/sub1", Kind: "Subscription", Group: "apps.open-cluster-management.io", Status: ComponentStatusReady}
+	dpl := appv1beta1.ObjectStatus{Name: "ns1/dpl1", Kind: "Deployable", Group: "apps.open-cluster-management.io"}
+
+	hash := GetMembershipHash(appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{sub, dpl}})
+	g.Expect(hash).To(gomega.HaveLen(64))
+
+	// the order and the health of the components don't change the hash
+	sub.Status = ComponentStatusFailed
+	g.Expect(GetMembershipHash(appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{dpl, sub}})).To(gomega.Equal(hash))
+
+	// a component leaving the application does
+	g.Expect(GetMembershipHash(appv1beta1.ComponentList{Objects: []appv1beta1.ObjectStatus{sub}})).NotTo(gomega.Equal(hash))
+}
+
+func TestSetAppCondition(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	status := &appv1beta1.ApplicationStatus{}
+
+	SetAppCondition(status, appv1beta1.Ready, corev1.ConditionFalse, ReasonNoComponents, "none")
+	g.Expect(status.Conditions).To(gomega.HaveLen(1))
+
+	oldStatus := status.DeepCopy()
+
+	// same condition, nothing moves
+	SetAppCondition(status, appv1beta1.Ready, corev1.ConditionFalse, ReasonNoComponents, "none")
+	g.Expect(IsAppStatusChanged(oldStatus, status)).To(gomega.BeFalse())
+
+	SetAppCondition(status, appv1beta1.Ready, corev1.ConditionTrue, ReasonComponentsReady, "all ready")
+	g.Expect(IsAppStatusChanged(oldStatus, status)).To(gomega.BeTrue())
+	g.Expect(status.Conditions).To(gomega.HaveLen(1))
+
+	cond := GetAppCondition(status, appv1beta1.Ready)
+	g.Expect(cond).NotTo(gomega.BeNil())
+	g.Expect(cond.Status).To(gomega.Equal(corev1.ConditionTrue))
+	g.Expect(cond.Reason).To(gomega.Equal(ReasonComponentsReady))
+
+	g.Expect(GetAppCondition(status, appv1beta1.Error)).To(gomega.BeNil())
+}
+
+func TestGetAppStatusPatch(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	status := &appv1beta1.ApplicationStatus{ObservedGeneration: 2}
+
+	patch, err := GetAppStatusPatch(status, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(patch)).To(gomega.MatchJSON(`{"status": {"observedGeneration": 2, "conditions": null,
+		"components": null, "componentsReady": null, "clusters": null}}`))
+
+	status.ComponentsReady = "1/1"
+	status.ComponentList.Objects = []appv1beta1.ObjectStatus{{Name: "ns1/sub1", Kind: "Subscription", Status: ComponentStatusReady}}
+
+	patch, err = GetAppStatusPatch(status, []ClusterStatus{{Name: "cluster1", Phase: ClusterPhaseDeployed}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(string(patch)).To(gomega.MatchJSON(`{"status": {"observedGeneration": 2, "conditions": null,
+		"components": [{"name": "ns1/sub1", "kind": "Subscription", "status": "Ready"}], "componentsReady": "1/1",
+		"clusters": [{"name": "cluster1", "phase": "Deployed"}]}}`))
+}